@@ -0,0 +1,43 @@
+package zap2telegram
+
+import "sync"
+
+// asyncDispatcher holds the async messages waiting to be sent by the dispatchers, see WithAsyncWorkers
+type asyncDispatcher struct {
+	mu      sync.RWMutex // guards the entries channel against being closed while enqueuing
+	entries chan chanEntry
+	stopped bool
+	stop    chan struct{} // closed once stopped
+}
+
+func newAsyncDispatcher(buffer int) *asyncDispatcher {
+	return &asyncDispatcher{entries: make(chan chanEntry, buffer), stop: make(chan struct{})}
+}
+
+// enqueue adds an entry to be sent by the dispatchers, it reports whether the entry was queued and whether
+// the dispatcher is still running (the entry isn't queued when it's full or stopped)
+func (d *asyncDispatcher) enqueue(ce chanEntry) (queued, running bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.stopped {
+		return false, false
+	}
+	select {
+	case d.entries <- ce:
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// close stops the dispatchers once they've sent the entries already queued
+func (d *asyncDispatcher) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	d.stopped = true
+	close(d.entries)
+	close(d.stop)
+}
@@ -0,0 +1,103 @@
+package zap2telegram
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCloseStopsAsyncDispatchers(t *testing.T) {
+	before := runtime.NumGoroutine()
+	sent := make(chan string, 10)
+	core, err := NewTelegramCore("", []int64{1}, WithAsyncWorkers(4), WithDryRun(func(_ int64, text string) {
+		sent <- text
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+	logger.Error("before close")
+	if err := core.(*TelegramCore).Close(context.Background()); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	logger.Error("after close") // sent synchronously
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(sent))
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines after Close, want %d", n, before)
+	}
+}
+
+func TestContextStopsAsyncDispatchers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	core, err := NewTelegramCore("", []int64{1}, WithContext(ctx), WithDryRun(func(int64, string) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	d := core.(*TelegramCore).dispatcher
+	select {
+	case <-d.stop:
+	case <-time.After(time.Second):
+		t.Fatal("dispatchers still running after the root context is done")
+	}
+}
+
+// BenchmarkAsyncWrite reports the throughput and the goroutines used to send a burst of async entries: the dispatchers
+// keep them bounded no matter the entries being sent, unlike the goroutine per entry used before (the baseline)
+func BenchmarkAsyncWrite(b *testing.B) {
+	latency := WithDryRun(func(int64, string) { time.Sleep(100 * time.Microsecond) }) // a fast bot API call
+	b.Run("dispatchers", func(b *testing.B) {
+		core, err := NewTelegramCore("", []int64{1}, latency, WithAsyncBuffer(b.N+1))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer core.(*TelegramCore).Close(context.Background())
+		logger := zap.New(core)
+		benchmarkBurst(b, func(i int) { logger.Error("burst", zap.Int("i", i)) }, func() {
+			if err := logger.Sync(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	})
+	b.Run("goroutine per entry", func(b *testing.B) {
+		core, err := NewTelegramCore("", []int64{1}, latency, WithoutAsyncOpt())
+		if err != nil {
+			b.Fatal(err)
+		}
+		logger := zap.New(core)
+		var wg sync.WaitGroup
+		benchmarkBurst(b, func(i int) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				logger.Error("burst", zap.Int("i", i))
+			}()
+		}, wg.Wait)
+	})
+}
+
+// benchmarkBurst logs b.N entries with write then waits for them to be sent, reporting the max goroutines
+func benchmarkBurst(b *testing.B, write func(i int), wait func()) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	maxGoroutines := 0
+	for i := 0; i < b.N; i++ {
+		write(i)
+		if n := runtime.NumGoroutine(); n > maxGoroutines {
+			maxGoroutines = n
+		}
+	}
+	wait()
+	b.StopTimer()
+	b.ReportMetric(float64(maxGoroutines), "max-goroutines")
+}
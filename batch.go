@@ -0,0 +1,229 @@
+package zap2telegram
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultEntrySeparator separates the entries merged into the same message by default
+const defaultEntrySeparator = "\n\n"
+
+// batchItem is a queued entry rendered for a chat
+type batchItem struct {
+	index      int // index of the entry in the batch
+	e          zapcore.Entry
+	o          entryOverrides
+	fields     []zapcore.Field // regular fields, to render the entry again as plain text
+	m          message
+	silent     bool // sent without notification
+	suppressed int  // messages suppressed since the previous one, see WithPerChatLevelThrottle
+}
+
+// sendBatch sends the queued entries merging the consecutive ones of each chat into as few messages as possible
+// within the message length limit, so a burst of N short entries takes a few API calls instead of N.
+// It returns the error of each entry.
+func (c *telegramClient) sendBatch(entries []chanEntry) []error {
+	errs := make([]error, len(entries))
+	if c.statusMessage != nil {
+		for i, ce := range entries {
+			errs[i] = c.sendMessage(ce.entry, ce.fields) // a single message anyway
+		}
+		return errs
+	}
+	var chatIDs []int64
+	items := map[int64][]batchItem{}
+	for i, ce := range entries {
+		entryChatIDs, entryItems, err := c.renderBatchItems(i, ce)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		for j, chatID := range entryChatIDs {
+			if _, ok := items[chatID]; !ok {
+				chatIDs = append(chatIDs, chatID)
+			}
+			items[chatID] = append(items[chatID], entryItems[j])
+		}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, chatID := range chatIDs {
+		chatID, chatItems := chatID, items[chatID]
+		wg.Add(1)
+		c.chatWorker(chatID).submit(func() error {
+			c.sendSlots <- struct{}{}
+			defer func() { <-c.sendSlots }()
+			parseMode := c.chatParseMode(chatID)
+			allowed := chatItems[:0]
+			for _, it := range chatItems {
+				suppressed, ok := c.allowChatLevel(chatID, it.e.Level)
+				if !ok {
+					continue
+				}
+				it.suppressed = suppressed
+				it.m = c.addSuppressedNote(it.m, parseMode, suppressed)
+				it.o = c.chatOverrides(chatID, it.o)
+				allowed = append(allowed, it)
+			}
+			for _, group := range c.groupBatch(allowed) {
+				if err := c.sendGroup(chatID, group); err != nil {
+					mu.Lock()
+					for _, it := range group {
+						errs[it.index] = errors.Join(errs[it.index], err)
+					}
+					mu.Unlock()
+				}
+			}
+			return nil
+		}, func(err error) {
+			defer wg.Done()
+			if err == nil {
+				return
+			}
+			mu.Lock() // the job panicked, the entries of the chat may not have been sent
+			for _, it := range chatItems {
+				errs[it.index] = errors.Join(errs[it.index], err)
+			}
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return errs
+}
+
+// renderBatchItems renders the i-th entry of the batch for each of its chats, a panic (E.g: in a custom formatter)
+// only fails this entry
+func (c *telegramClient) renderBatchItems(i int, ce chanEntry) (chatIDs []int64, items []batchItem, err error) {
+	defer recoverPanic(&err)
+	e, o, fields := c.prepareEntry(ce.entry, ce.fields)
+	msgs := make(map[string]message, 1)
+	chatIDs = c.entryChatIDs(o)
+	for _, chatID := range chatIDs {
+		parseMode := c.chatParseMode(chatID)
+		m, ok := msgs[parseMode]
+		if !ok {
+			m = c.renderMessage(e, o, fields, parseMode)
+			msgs[parseMode] = m
+		}
+		items = append(items, batchItem{
+			index: i, e: e, o: o, fields: fields, m: m, silent: c.isNotificationDisabled(chatID, e, o),
+		})
+	}
+	return chatIDs, items, nil
+}
+
+// groupBatch splits the items of a chat into groups sent as a single message. Only the consecutive plain text
+// messages sent to the same topic are merged, the rest are sent on their own.
+func (c *telegramClient) groupBatch(items []batchItem) [][]batchItem {
+	limit := maxMessageLength
+	if c.maxMessageLength > 0 && c.maxMessageLength < limit {
+		limit = c.maxMessageLength
+	}
+	separatorLength := textLength(c.entrySeparator)
+	var groups [][]batchItem
+	var current []batchItem
+	currentLength := 0
+	for _, it := range items {
+		length := textLength(it.m.text())
+		if !c.isMergeable(it, length, limit) {
+			if len(current) > 0 {
+				groups, current = append(groups, current), nil
+			}
+			groups = append(groups, []batchItem{it})
+			continue
+		}
+		if len(current) > 0 && (current[0].o.threadID != it.o.threadID || currentLength+separatorLength+length > limit) {
+			groups, current = append(groups, current), nil
+		}
+		if len(current) == 0 {
+			currentLength = length
+		} else {
+			currentLength += separatorLength + length
+		}
+		current = append(current, it)
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	return groups
+}
+
+// isMergeable reports whether the item can be merged with others into the same message
+func (c *telegramClient) isMergeable(it batchItem, length, limit int) bool {
+	return it.m.entities == nil && it.m.attachment == nil && it.o.photo == nil && it.o.replyTo == 0 && length <= limit &&
+		(c.largeMessageThreshold <= 0 || length <= c.largeMessageThreshold)
+}
+
+// isGroupSilent reports whether a group is sent without notification: only when all its items would be,
+// so a merged message notifies if any of its entries would notify on its own
+func isGroupSilent(group []batchItem) bool {
+	for _, it := range group {
+		if !it.silent {
+			return false
+		}
+	}
+	return true
+}
+
+// groupLevel returns the highest level of the items of a group
+func groupLevel(group []batchItem) zapcore.Level {
+	l := group[0].e.Level
+	for _, it := range group[1:] {
+		if it.e.Level > l {
+			l = it.e.Level
+		}
+	}
+	return l
+}
+
+// sendGroup sends a group of items to the chat as a single message
+func (c *telegramClient) sendGroup(chatID int64, group []batchItem) error {
+	parseMode := c.chatParseMode(chatID)
+	if len(group) == 1 {
+		it := group[0]
+		return c.sendToChat(chatID, it.e, it.o, parseMode, it.m, func() message {
+			return c.addSuppressedNote(c.renderMessage(it.e, it.o, it.fields, ""), "", it.suppressed)
+		})
+	}
+	texts := make([]string, len(group))
+	for i, it := range group {
+		texts[i] = it.m.text()
+	}
+	msg := tgbotapi.NewMessage(chatID, strings.Join(texts, c.entrySeparator))
+	msg.ParseMode = parseMode
+	msg.DisableNotification = isGroupSilent(group)
+	extra := c.withMessageEffect(c.extraParams(group[0].o), groupLevel(group))
+	sent, err := c.sendWithParams(group[0].e.Level, msg, extra)
+	if err != nil && parseMode != "" && isParseEntitiesError(err) {
+		c.handleError(fmt.Errorf("message to chat %d sent as plain text, failed to parse it as %s: %w", chatID, parseMode, err))
+		for i, it := range group {
+			texts[i] = c.addSuppressedNote(c.renderMessage(it.e, it.o, it.fields, ""), "", it.suppressed).text()
+		}
+		msg.Text, msg.ParseMode = strings.Join(texts, c.entrySeparator), ""
+		sent, err = c.sendWithParams(group[0].e.Level, msg, extra)
+	}
+	if err != nil && isMessageTooLongError(err) {
+		var errs []error // the entries are sent one by one, see sendToChat
+		for _, it := range group {
+			errs = append(errs, c.sendGroup(chatID, []batchItem{it}))
+		}
+		return errors.Join(errs...)
+	}
+	if err != nil {
+		err := fmt.Errorf("failed to send message to chat %d: %w", chatID, err)
+		for _, it := range group {
+			c.observer.failed(it.e.Level, err)
+		}
+		return err
+	}
+	c.setLastMessageID(chatID, sent.MessageID)
+	for _, it := range group {
+		c.observer.sent(it.e.Level)
+	}
+	return nil
+}
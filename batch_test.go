@@ -0,0 +1,79 @@
+package zap2telegram
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestBatchNotification(t *testing.T) {
+	tests := []struct {
+		name       string
+		levels     []zapcore.Level
+		wantSilent string
+	}{
+		{"mixed levels notify", []zapcore.Level{zapcore.InfoLevel, zapcore.ErrorLevel, zapcore.InfoLevel}, ""},
+		{"silent levels only", []zapcore.Level{zapcore.InfoLevel, zapcore.WarnLevel}, "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeTelegram(t)
+			core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithLevel(zapcore.InfoLevel),
+				WithManualQueue(10), WithQueueBatching(), WithNotificationOn([]zapcore.Level{zapcore.ErrorLevel}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger := zap.New(core)
+			for _, l := range tt.levels {
+				logger.Log(l, "entry")
+			}
+			if err := core.(*TelegramCore).Flush(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			sent := f.sent("sendMessage")
+			if len(sent) != 1 {
+				t.Fatalf("sent %d messages, want a single merged one", len(sent))
+			}
+			if got := sent[0]["disable_notification"]; got != tt.wantSilent {
+				t.Errorf("disable_notification = %q, want %q", got, tt.wantSilent)
+			}
+		})
+	}
+}
+
+// BenchmarkQueueBurst reports the API calls taken to flush a burst of 50 short queued entries,
+// with and without WithQueueBatching
+func BenchmarkQueueBurst(b *testing.B) {
+	for _, batching := range []bool{false, true} {
+		name := "without batching"
+		if batching {
+			name = "with batching"
+		}
+		b.Run(name, func(b *testing.B) {
+			var calls atomic.Int64
+			opts := []Option{WithManualQueue(50), WithDryRun(func(int64, string) { calls.Add(1) })}
+			if batching {
+				opts = append(opts, WithQueueBatching())
+			}
+			core, err := NewTelegramCore("", []int64{1}, opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			logger := zap.New(core)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 50; j++ {
+					logger.Error("burst", zap.Int("j", j))
+				}
+				if err := core.(*TelegramCore).Flush(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(calls.Load())/float64(b.N), "api-calls/op")
+		})
+	}
+}
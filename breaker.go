@@ -0,0 +1,59 @@
+package zap2telegram
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// circuitBreaker stops sending messages to Telegram after too many consecutive failures
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int           // consecutive failures needed to open the circuit
+	cooldown  time.Duration // time the circuit stays open before probing the recovery
+	failures  int           // current consecutive failures
+	openedAt  time.Time     // last time the circuit was opened
+	probing   bool          // a probe message is being sent (half-open circuit)
+	open      atomic.Bool   // the circuit is open (or half-open) until a message is sent, readable without the lock
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a message can be sent. Once the cool-down period of an open circuit
+// has elapsed, a single message is let through to probe whether Telegram has recovered.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true // closed
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false // open
+	}
+	b.probing = true // half-open
+	return true
+}
+
+// record updates the circuit state with the result of a message sending
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.open.Store(false)
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.open.Store(true)
+	}
+}
+
+// isOpen reports whether the circuit is open (or half-open), it doesn't take the lock
+func (b *circuitBreaker) isOpen() bool {
+	return b.open.Load()
+}
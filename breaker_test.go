@@ -0,0 +1,64 @@
+package zap2telegram
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	f := newFakeTelegram(t)
+	var down atomic.Bool
+	down.Store(true)
+	f.fail = func(r fakeRequest) *tgbotapi.Error {
+		if r.method == "sendMessage" && down.Load() {
+			return &tgbotapi.Error{Code: http.StatusBadGateway, Message: "Bad Gateway"}
+		}
+		return nil
+	}
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithCircuitBreaker(2, cooldown))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func() error {
+		return core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "down"}, nil)
+	}
+	steps := []struct {
+		name     string
+		wait     bool // wait for the cooldown first
+		recover  bool // Telegram is up again
+		wantOpen bool // the message isn't even sent
+		wantErr  bool
+		wantSent int // messages sent so far
+	}{
+		{name: "closed, first failure", wantErr: true, wantSent: 1},
+		{name: "closed, second failure opens", wantErr: true, wantSent: 2},
+		{name: "open", wantOpen: true, wantErr: true, wantSent: 2},
+		{name: "half-open probe fails", wait: true, wantErr: true, wantSent: 3},
+		{name: "open again", wantOpen: true, wantErr: true, wantSent: 3},
+		{name: "half-open probe succeeds", wait: true, recover: true, wantSent: 4},
+		{name: "closed", wantSent: 5},
+	}
+	for _, step := range steps {
+		if step.wait {
+			time.Sleep(cooldown)
+		}
+		if step.recover {
+			down.Store(false)
+		}
+		err := write()
+		if (err != nil) != step.wantErr || errors.Is(err, ErrCircuitOpen) != step.wantOpen {
+			t.Fatalf("%s: Write() = %v, want error %v (circuit open %v)", step.name, err, step.wantErr, step.wantOpen)
+		}
+		if got := len(f.sent("sendMessage")); got != step.wantSent {
+			t.Fatalf("%s: sent %d messages, want %d", step.name, got, step.wantSent)
+		}
+	}
+}
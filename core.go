@@ -4,7 +4,10 @@ package zap2telegram
 import (
 	"context"
 	"errors"
+	"fmt"
 	"go.uber.org/zap"
+	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap/zapcore"
@@ -15,23 +18,57 @@ const (
 	defaultLevel    = zapcore.WarnLevel // send messages equal or above this level
 	defaultAsyncOpt = true              // send messages asynchronously by default
 	defaultQueueOpt = false             // disable queue by default
+
+	defaultAsyncWorkers = 1    // async messages are sent one at a time in the order they were logged by default
+	defaultAsyncBuffer  = 1024 // async messages waiting to be sent
+
+	defaultFlushTimeout = 10 * time.Second // max time Sync waits for the pending messages to be sent
+
+	constructionRetryInterval = 30 * time.Second // interval between the bot API creation retries, see WithConstructionFallback
 )
 
-// All levels provided by zap
+// All levels provided by zap (except DPanic) ordered by severity
 var AllLevels = [6]zapcore.Level{
 	zapcore.DebugLevel,
 	zapcore.InfoLevel,
 	zapcore.WarnLevel,
 	zapcore.ErrorLevel,
-	zapcore.FatalLevel,
 	zapcore.PanicLevel,
+	zapcore.FatalLevel,
 }
 
+// Errors returned when a message is not sent
+var (
+	ErrCircuitOpen  = errors.New("circuit breaker is open, message not sent")
+	ErrUnauthorized = errors.New("bot is unauthorized (E.g: revoked token), messages are not sent until the core is enabled again")
+	ErrNotReady     = errors.New("bot API not created yet, entries are written to the construction fallback core")
+)
+
 // Posible errors when creating a new Zap Core
 var (
-	ErrBotAccessToken = errors.New("bot access token not defined")
-	ErrChatIDs        = errors.New("chat ids not defined")
-	ErrAsyncOpt       = errors.New("async option not worked with queue option")
+	ErrBotAccessToken        = errors.New("bot access token not defined")
+	ErrChatIDs               = errors.New("chat ids not defined")
+	ErrAsyncOpt              = errors.New("async option not worked with queue option")
+	ErrLargeMessageThreshold = errors.New("large message threshold must be greater than zero")
+	ErrMaxMessageLength      = errors.New("max message length must be greater than zero")
+	ErrCircuitBreaker        = errors.New("circuit breaker failures and cooldown must be greater than zero")
+	ErrQueueSize             = errors.New("queue size must be greater than zero")
+	ErrQueueInterval         = errors.New("queue interval must be greater than zero")
+	ErrQueueJitter           = errors.New("queue jitter must be greater than zero and less than one")
+	ErrIdempotencyStore      = errors.New("idempotency store can't be nil and its ttl must be greater than zero")
+	ErrSampling              = errors.New("sampling tick must be greater than zero and first and thereafter not negative")
+	ErrGlobalThrottle        = errors.New("global throttle max messages and window must be greater than zero")
+	ErrPerChatLevelThrottle  = errors.New("per chat level throttle interval must be greater than zero")
+	ErrLevelRateLimit        = errors.New("level rate limit intervals must be greater than zero")
+	ErrBotAPI                = errors.New("bot api not defined")
+	ErrSendTimeout           = errors.New("send timeout must be greater than zero")
+	ErrParseMode             = errors.New("parse mode must be Markdown, MarkdownV2 or HTML")
+	ErrMaxInflight           = errors.New("max inflight messages must be greater than zero")
+	ErrAsyncWorkers          = errors.New("async workers must be greater than zero")
+	ErrAsyncBuffer           = errors.New("async buffer size must be greater than zero")
+	ErrHistorySize           = errors.New("history size must be greater than zero")
+	ErrLevelEnabler          = errors.New("level enabler must not be nil")
+	ErrAPIEndpoint           = errors.New("api endpoint must be an http(s) url with two %s verbs for the token and the method")
 )
 
 type TelegramCore struct {
@@ -39,43 +76,114 @@ type TelegramCore struct {
 	// inheritedFields is a collection of fields that have been added to the logger
 	// through the use of `.With()`. These fields should never be cleared after
 	// logging a single entry.
-	inheritedFields []zapcore.Field
-	telegramClient  *telegramClient      // telegram client
-	enabler         zapcore.LevelEnabler // only send message if level is in this list
-	async           bool                 // send messages asynchronously
-	queue           bool                 // use a queue to send messages
-	intervalQueue   time.Duration        // queue interval between messages sending
-	entriesChan     chan chanEntry       // channel to store messages in queue
+	inheritedFields      []zapcore.Field
+	telegramClient       *telegramClient                           // telegram client
+	enabler              zapcore.LevelEnabler                      // only send message if level is in this list
+	async                bool                                      // send messages asynchronously
+	asyncWorkers         int                                       // dispatchers sending the async messages
+	asyncBuffer          int                                       // async messages waiting to be sent
+	dispatcher           *asyncDispatcher                          // async messages waiting to be sent by the dispatchers
+	queue                bool                                      // use a queue to send messages
+	intervalQueue        time.Duration                             // queue interval between messages sending
+	queueCtx             context.Context                           // context to stop consuming the queue
+	queueJitter          float64                                   // randomize the queue interval by up to this fraction
+	queueCoalesce        bool                                      // coalesce identical consecutive queued entries into a single message
+	queueBatch           bool                                      // merge the queued entries of each chat into as few messages as possible
+	manualQueue          bool                                      // the queue is only flushed on demand (Flush/Sync), there's no queue consumer
+	entriesChan          chan chanEntry                            // channel to store messages in queue
+	fallback             zapcore.Core                              // core to write the entries that failed to be sent
+	constructionFallback zapcore.Core                              // core to write the entries to until the bot API is created
+	botReady             *atomic.Bool                              // the bot API has been created (nil if it was created along with the core)
+	breaker              *circuitBreaker                           // stop sending messages after too many consecutive failures
+	sampler              *sampler                                  // cap the messages sent per level and message
+	throttle             *throttle                                 // cap the messages sent across all levels per time window
+	levelRateLimiter     *levelRateLimiter                         // min interval between the messages sent per level
+	idempotencyStore     IdempotencyStore                          // don't send again the entries sent within the idempotency ttl
+	idempotencyTTL       time.Duration                             // time window of the idempotency store keys
+	entryFilter          func(zapcore.Entry, []zapcore.Field) bool // only send the entries passing this filter
+	requiredFields       []requiredField                           // only send the entries with all these fields
+	skipEmpty            bool                                      // don't send the entries with neither message nor fields
+	startupMessage       *string                                   // message sent once the core is created
+	shutdownMessage      func(Stats) string                        // message sent by Close
+	inflight             chan struct{}                             // semaphore capping the async messages being sent at the same time
+	stats                *coreStats                                // delivery counters shared with the cores created with With()
+	pending              *pendingGroup                             // async messages being sent, shared with the cores created with With()
+	unauthorized         *atomic.Bool                              // the bot is unauthorized so messages are not sent, shared with the cores created with With()
+	mutedLevels          *atomic.Uint32                            // bitmask of the levels not sent to telegram, shared with the cores created with With()
+	lastErr              *atomic.Pointer[error]                    // last error sending an async or queued message, shared with the cores created with With()
+	scheduler            *scheduler                                // scheduled entries waiting to be sent (see ScheduleAt), shared with the cores created with With()
+	sequence             *atomic.Uint64                            // sequence number of the last entry, shared with the cores created with With()
 }
 type chanEntry struct {
 	entry  zapcore.Entry
 	fields []zapcore.Field
+	ctx    context.Context // context set through ContextField (if any)
 }
 
 // NewTelegramCore returns a new zap2telegram instance configured with the given options
 func NewTelegramCore(botAccessToken string, chatIDs []int64, opts ...Option) (zapcore.Core, error) {
-	if botAccessToken == "" {
-		return nil, ErrBotAccessToken
-	} else if len(chatIDs) == 0 {
-		return nil, ErrChatIDs
-	}
-	telegramClient, err := newTelegramClient(botAccessToken, chatIDs)
-	if err != nil {
-		return nil, err
-	}
 	c := &TelegramCore{
 		inheritedFields: []zapcore.Field{},
-		telegramClient:  telegramClient,
+		telegramClient:  newTelegramClient(botAccessToken, chatIDs),
 		enabler:         zap.NewAtomicLevelAt(defaultLevel),
 		async:           defaultAsyncOpt,
+		asyncWorkers:    defaultAsyncWorkers,
+		asyncBuffer:     defaultAsyncBuffer,
 		queue:           defaultQueueOpt,
+		stats:           &coreStats{},
+		pending:         newPendingGroup(),
+		unauthorized:    &atomic.Bool{},
+		mutedLevels:     &atomic.Uint32{},
+		lastErr:         &atomic.Pointer[error]{},
+		scheduler:       newScheduler(),
 	}
+	c.telegramClient.observer.stats = c.stats
 	// apply options
 	for _, opt := range opts {
 		if err := opt(c); err != nil {
 			return nil, err
 		}
 	}
+	if len(chatIDs) == 0 && !c.telegramClient.allowEmptyChats {
+		return nil, ErrChatIDs
+	}
+	if botAccessToken == "" && c.telegramClient.injectedBotAPI == nil && !c.telegramClient.isOffline() {
+		return nil, ErrBotAccessToken // the access token is only optional when the bot API is provided with WithBotAPI or never called
+	}
+	if err := c.telegramClient.initBotAPI(); err != nil {
+		if c.constructionFallback == nil {
+			return nil, err
+		}
+		// degrade to the fallback core until the bot API can be created
+		c.botReady = &atomic.Bool{}
+		go c.retryInitBotAPI()
+	} else if c.startupMessage != nil {
+		if err := c.telegramClient.sendText(*c.startupMessage); err != nil {
+			return nil, fmt.Errorf("failed to send startup message: %w", err)
+		}
+	}
+	// start the dispatchers and consuming the queue only once all options have been applied successfully,
+	// so nothing is leaked on error
+	if c.async {
+		c.dispatcher = newAsyncDispatcher(c.asyncBuffer)
+		for i := 0; i < c.asyncWorkers; i++ {
+			go c.dispatchAsync()
+		}
+		if ctx := c.telegramClient.ctx; ctx != nil {
+			go func() {
+				select {
+				case <-ctx.Done(): // the outstanding requests are aborted, see WithContext
+					c.dispatcher.close()
+				case <-c.dispatcher.stop:
+				}
+			}()
+		}
+	}
+	if c.queue && !c.manualQueue {
+		go func() {
+			_ = c.consumeEntriesQueue(c.queueCtx)
+		}()
+	}
 	return c, nil
 }
 
@@ -89,16 +197,80 @@ func (c *TelegramCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry)
 	return checked
 }
 func (c *TelegramCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
-	entryFields := append(fields, c.inheritedFields...) // fields passed for the current entry log entry + inherited fields
-	if c.async {
-		go func() {
-			_ = c.telegramClient.sendMessage(entry, entryFields)
-		}()
-	} else if c.queue {
-		c.entriesChan <- chanEntry{entry, entryFields}
+	entryFields := mergeFields(fields, c.inheritedFields) // fields passed for the current entry log entry + inherited fields
+	if !c.Ready() {
+		if !c.constructionFallback.Enabled(entry.Level) {
+			return nil
+		}
+		return c.constructionFallback.Write(entry, entryFields)
+	}
+	if !c.telegramClient.hasChats(entryFields) {
+		return nil // no chats yet (see WithAllowEmptyChats), nothing is sent nor accounted
+	}
+	if c.isLevelMuted(entry.Level) {
+		return nil
+	}
+	if c.entryFilter != nil && !c.entryFilter(entry, entryFields) {
+		return nil
+	}
+	if c.skipEmpty && isEmptyEntry(entry, entryFields) {
+		return nil
+	}
+	for _, r := range c.requiredFields {
+		if !r.matches(entryFields) {
+			return nil
+		}
+	}
+	if c.sampler != nil && !c.sampler.allow(entry) {
+		c.drop(entry.Level, DropReasonSampled)
+		return nil
+	}
+	if c.levelRateLimiter != nil && !c.levelRateLimiter.allow(entry.Level, time.Now()) {
+		c.drop(entry.Level, DropReasonRateLimited)
+		return nil
+	}
+	if c.throttle != nil {
+		if allowed, notice := c.throttle.allow(time.Now()); !allowed {
+			c.drop(entry.Level, DropReasonThrottled)
+			if notice {
+				c.sendThrottleNotice()
+			}
+			return nil
+		}
+	}
+	if c.idempotencyStore != nil {
+		// the key is removed again if the entry is dropped or fails to be sent, see forgetIdempotencyKey
+		added, err := c.idempotencyStore.SetIfAbsent(idempotencyKey(entry, entryFields), c.idempotencyTTL)
+		if err != nil {
+			c.telegramClient.handleError(fmt.Errorf("failed to check idempotency store: %w", err)) // send it anyway
+		} else if !added {
+			c.drop(entry.Level, DropReasonDuplicate)
+			return nil
+		}
+	}
+	if c.sequence != nil {
+		// numbered once accepted so the gaps reveal the entries lost while being delivered
+		entryFields = append(entryFields, sequenceField(c.sequence.Add(1)))
+	}
+	if at, ok := scheduleTime(entryFields); ok && time.Now().Before(at) {
+		c.scheduler.schedule(at, func() { c.sendScheduled(entry, entryFields) })
+		return nil
+	}
+	if c.async && c.dispatcher != nil && c.dispatch(entry, entryFields) {
+		return nil
+	}
+	if c.queue && c.entriesChan != nil {
+		select {
+		case c.entriesChan <- chanEntry{entry, entryFields, contextFromFields(entryFields)}:
+			c.telegramClient.observer.queued(entry.Level)
+		default:
+			c.drop(entry.Level, DropReasonQueueFull)
+			c.forgetIdempotencyKey(entry, entryFields)
+		}
 	} else {
-		// if async or queue option is not set, send message immediately synchronously (blocking)
-		if err := c.telegramClient.sendMessage(entry, entryFields); err != nil {
+		// if async or queue option is not set (or the queue is missing or the dispatchers are stopped),
+		// send message immediately synchronously (blocking)
+		if err := c.sendSync(entry, entryFields); err != nil {
 			return err
 		}
 	}
@@ -109,43 +281,541 @@ func (c *TelegramCore) With(fields []zapcore.Field) zapcore.Core {
 	cloned.inheritedFields = append(cloned.inheritedFields, fields...)
 	return &cloned
 }
+
+// Sync flushes the pending messages waiting up to 10 seconds, see Flush
 func (c *TelegramCore) Sync() error {
-	if c.queue {
-		c.handleNewQueueEntries()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFlushTimeout)
+	defer cancel()
+	return c.Flush(ctx)
+}
+
+// Flush sends all the entries in the queue and waits for the async messages being sent.
+// The scheduled entries aren't sent before their time, see ScheduleAt and Close.
+// It returns the context error if the context is done before everything has been delivered.
+func (c *TelegramCore) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if c.queue {
+			c.flushOnce()
+		}
+		c.pending.Wait()
+		c.scheduler.wait()
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if c.constructionFallback != nil && !c.Ready() {
+		return c.constructionFallback.Sync()
+	}
+	if c.fallback != nil {
+		return c.fallback.Sync()
 	}
 	return nil
 }
 
-// consumeEntriesQueue sends all the entries (messages) in the queue to telegram at the given interval
-func (h TelegramCore) consumeEntriesQueue(ctx context.Context) error {
-	ticker := time.NewTicker(h.intervalQueue)
-	defer ticker.Stop()
+// FatalHook returns a hook for zap.WithFatalHook flushing the core (see Sync) before running the next hook
+// (zapcore.WriteThenFatal if nil), so the fatal entries are sent to Telegram before the process exits
+// instead of racing os.Exit (E.g: zap.New(core, zap.WithFatalHook(core.FatalHook(zapcore.WriteThenGoexit)))).
+func (c *TelegramCore) FatalHook(next zapcore.CheckWriteHook) zapcore.CheckWriteHook {
+	if next == nil {
+		next = zapcore.WriteThenFatal
+	}
+	return fatalHook{core: c, next: next}
+}
+
+// fatalHook flushes the core before running the next hook, see FatalHook
+type fatalHook struct {
+	core *TelegramCore
+	next zapcore.CheckWriteHook
+}
 
+func (h fatalHook) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	_ = h.core.Sync()
+	h.next.OnWrite(ce, fields)
+}
+
+// retryInitBotAPI retries creating the bot API until it succeeds (or the core context is done),
+// the entries are written to the construction fallback core meanwhile
+func (c *TelegramCore) retryInitBotAPI() {
+	ctx := c.telegramClient.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ticker := time.NewTicker(constructionRetryInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			h.handleNewQueueEntries()
+			if err := c.telegramClient.initBotAPI(); err != nil {
+				c.telegramClient.handleError(err)
+				continue
+			}
+			c.botReady.Store(true)
+			if c.startupMessage != nil {
+				_ = c.telegramClient.sendText(*c.startupMessage)
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Ready reports whether the bot API has been created, it's only false while the entries are written to
+// the construction fallback core (see WithConstructionFallback)
+func (c *TelegramCore) Ready() bool {
+	return c.botReady == nil || c.botReady.Load()
+}
+
+// Close sends the scheduled entries right away (see ScheduleAt), stops the async dispatchers once they've sent
+// the messages already queued, flushes the core like Flush and then sends the shutdown message (if any),
+// see WithShutdownMessage. The entries logged afterwards are sent synchronously.
+func (c *TelegramCore) Close(ctx context.Context) error {
+	c.scheduler.fireAll()
+	if c.dispatcher != nil {
+		c.dispatcher.close() // the queued async messages are still sent
+	}
+	if err := c.Flush(ctx); err != nil {
+		return err
+	}
+	if c.shutdownMessage == nil {
+		return nil
+	}
+	if !c.Ready() {
+		return ErrNotReady
+	}
+	if err := c.telegramClient.sendText(c.shutdownMessage(c.stats.snapshot())); err != nil {
+		return fmt.Errorf("failed to send shutdown message: %w", err)
+	}
+	return nil
+}
+
+// Saturated reports whether the core can't keep up with the entries being logged, so high-volume producers can
+// slow down or sample more aggressively upstream instead of having their entries dropped: the queue or the async
+// buffer is filled above its high-water mark (3/4 of its capacity), the circuit is open (see WithCircuitBreaker)
+// or the bot is unauthorized. It's cheap enough to be called on every entry.
+func (c *TelegramCore) Saturated() bool {
+	if c.unauthorized.Load() || (c.breaker != nil && c.breaker.isOpen()) {
+		return true
+	}
+	if c.queue && aboveHighWater(c.entriesChan) {
+		return true
+	}
+	return c.dispatcher != nil && aboveHighWater(c.dispatcher.entries)
+}
+
+// aboveHighWater reports whether the channel is filled above 3/4 of its capacity, see Saturated
+func aboveHighWater(ch chan chanEntry) bool {
+	return cap(ch) > 0 && len(ch)*4 >= cap(ch)*3
+}
+
+// QueueLen returns the number of entries waiting in the queue (0 if the queue is not used)
+func (c *TelegramCore) QueueLen() int {
+	if !c.queue {
+		return 0
+	}
+	return len(c.entriesChan)
+}
+
+// QueueCap returns the queue capacity (0 if the queue is not used)
+func (c *TelegramCore) QueueCap() int {
+	if !c.queue {
+		return 0
+	}
+	return cap(c.entriesChan)
+}
+
+// SendTest formats and sends a synthetic info entry with the given message to all chats synchronously,
+// to verify the alerting path works (E.g: from an admin endpoint after a configuration change).
+// The entry filters, sampling and circuit breaker are bypassed.
+func (c *TelegramCore) SendTest(message string) error {
+	if !c.Ready() {
+		return ErrNotReady
+	}
+	entry := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: message}
+	return c.telegramClient.sendMessage(entry, c.inheritedFields)
+}
+
+// AddChatID adds a chat id to send the messages to (nothing is done if it was already added)
+func (c *TelegramCore) AddChatID(chatID int64) {
+	c.telegramClient.addChatID(chatID)
+}
+
+// RemoveChatID stops sending the messages to the given chat id
+func (c *TelegramCore) RemoveChatID(chatID int64) {
+	c.telegramClient.removeChatID(chatID)
+}
+
+// Enable resumes sending messages after the bot was found unauthorized (see ErrUnauthorized),
+// E.g: once the bot access token has been fixed
+func (c *TelegramCore) Enable() {
+	c.unauthorized.Store(false)
+}
+
+// MuteLevel stops sending the entries of the given level to telegram until UnmuteLevel is called
+// (E.g: during a maintenance window). The level is still reported as enabled so the entries keep flowing
+// to the rest of cores.
+func (c *TelegramCore) MuteLevel(l zapcore.Level) {
+	bit := levelBit(l)
+	for {
+		old := c.mutedLevels.Load()
+		if c.mutedLevels.CompareAndSwap(old, old|bit) {
+			return
+		}
+	}
+}
+
+// UnmuteLevel resumes sending the entries of the given level muted with MuteLevel
+func (c *TelegramCore) UnmuteLevel(l zapcore.Level) {
+	bit := levelBit(l)
+	for {
+		old := c.mutedLevels.Load()
+		if c.mutedLevels.CompareAndSwap(old, old&^bit) {
+			return
+		}
+	}
+}
+
+// isLevelMuted reports whether the level was muted with MuteLevel
+func (c *TelegramCore) isLevelMuted(l zapcore.Level) bool {
+	return c.mutedLevels.Load()&levelBit(l) != 0
+}
+
+// levelBit returns the level bit in the muted levels bitmask
+func levelBit(l zapcore.Level) uint32 {
+	return 1 << uint32(l-zapcore.DebugLevel)
+}
+
+// LastMessageIDs returns the id of the last message sent to each chat, so following entries can reply to it (see ReplyTo)
+func (c *TelegramCore) LastMessageIDs() map[int64]int {
+	return c.telegramClient.getLastMessageIDs()
+}
+
+// drop records an entry dropped without being sent
+func (c *TelegramCore) drop(l zapcore.Level, reason DropReason) {
+	c.telegramClient.observer.dropped(l, reason) // counted by the observer
+}
+
+// Dropped returns the total number of entries dropped without being sent (E.g: queue full, sampled out, etc.).
+// The breakdown per reason is reported to the metrics observers implementing DropReasonObserver.
+func (c *TelegramCore) Dropped() uint64 {
+	return c.stats.dropped.Load()
+}
+
+// RecentMessages returns the last messages sent (or failed to be sent) from the oldest to the newest one,
+// nil unless enabled with WithHistory
+func (c *TelegramCore) RecentMessages() []SentRecord {
+	if c.telegramClient.history == nil {
+		return nil
+	}
+	return c.telegramClient.history.recent()
+}
+
+// LastError returns the last error sending an async or queued message (nil if none), since Write can't return them
+func (c *TelegramCore) LastError() error {
+	if err := c.lastErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// backgroundError records the error (if any) of an async or queued message and reports it to the error handler
+func (c *TelegramCore) backgroundError(err error) {
+	if err == nil {
+		return
+	}
+	c.lastErr.Store(&err)
+	if !errors.Is(err, ErrUnauthorized) { // already reported once
+		c.telegramClient.handleError(err)
+	}
+}
+
+// Stats returns the delivery counters since the core was created
+func (c *TelegramCore) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// sendThrottleNotice sends in the background the notice about the messages being dropped by the global throttle
+func (c *TelegramCore) sendThrottleNotice() {
+	c.pending.Add(1)
+	go func() {
+		defer c.pending.Done()
+		_ = c.telegramClient.sendText(fmt.Sprintf("(throttled) more than %d messages in %s, dropping messages until the rate goes down",
+			c.throttle.max, c.throttle.window))
+	}()
+}
+
+// sendSync sends the entry to telegram blocking until it's sent or the entry context set through ContextField
+// (if any) is done. The message is still sent in the background when the context is done first, see Flush.
+func (c *TelegramCore) sendSync(entry zapcore.Entry, fields []zapcore.Field) error {
+	ctx := contextFromFields(fields)
+	if ctx == nil {
+		return c.send(entry, fields)
+	}
+	if err := ctx.Err(); err != nil {
+		c.drop(entry.Level, DropReasonStale) // the logged operation is already over
+		return err
+	}
+	done := make(chan error, 1)
+	c.pending.Add(1)
+	go func() {
+		defer c.pending.Done()
+		done <- c.send(entry, fields)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send sends the entry to telegram, writing it to the fallback core (if any) when the delivery fails,
+// the circuit breaker is open or the bot is unauthorized
+func (c *TelegramCore) send(entry zapcore.Entry, fields []zapcore.Field) error {
+	err := c.blocked()
+	if err != nil {
+		c.telegramClient.observer.failed(entry.Level, err)
+	} else {
+		err = c.telegramClient.sendMessage(entry, fields)
+		c.record(err)
+	}
+	if err != nil {
+		c.forgetIdempotencyKey(entry, fields)
+	}
+	return c.writeFallback(entry, fields, err)
+}
+
+// dispatch queues an async message to be sent by the dispatchers, it reports false when they're stopped
+// (see Close) so the message is sent synchronously instead
+func (c *TelegramCore) dispatch(entry zapcore.Entry, fields []zapcore.Field) bool {
+	if c.inflight != nil {
+		select {
+		case c.inflight <- struct{}{}:
+		default:
+			c.drop(entry.Level, DropReasonMaxInflight)
+			c.forgetIdempotencyKey(entry, fields)
+			return true
+		}
+	}
+	c.pending.Add(1)
+	queued, running := c.dispatcher.enqueue(chanEntry{entry, fields, contextFromFields(fields)})
+	if queued {
+		return true
+	}
+	c.pending.Done()
+	if c.inflight != nil {
+		<-c.inflight
+	}
+	if running {
+		c.drop(entry.Level, DropReasonQueueFull)
+		c.forgetIdempotencyKey(entry, fields)
+	}
+	return running
+}
+
+// dispatchAsync sends the async messages as they're logged until the dispatchers are stopped, see WithAsyncWorkers
+func (c *TelegramCore) dispatchAsync() {
+	for ce := range c.dispatcher.entries {
+		c.sendAsync(ce)
+	}
+}
+
+// sendAsync sends an async message, recording its error (if any) since Write already returned
+func (c *TelegramCore) sendAsync(ce chanEntry) {
+	defer c.pending.Done()
+	if c.inflight != nil {
+		defer func() { <-c.inflight }()
+	}
+	defer func() {
+		if r := recover(); r != nil { // E.g: a custom formatter panic mustn't crash the process nor the dispatcher
+			c.backgroundError(fmt.Errorf("panic sending message: %v", r))
+		}
+	}()
+	c.backgroundError(c.send(ce.entry, ce.fields))
+}
+
+// sendScheduled sends a scheduled entry once its time has come, see ScheduleAt
+func (c *TelegramCore) sendScheduled(entry zapcore.Entry, fields []zapcore.Field) {
+	defer func() {
+		if r := recover(); r != nil { // E.g: a custom formatter panic mustn't crash the process
+			c.backgroundError(fmt.Errorf("panic sending message: %v", r))
+		}
+	}()
+	c.backgroundError(c.send(entry, fields))
+}
+
+// sendQueued sends the queued entries merging them into as few messages as possible, see WithQueueBatching.
+// The entries are sent as a single unit as far as the circuit breaker is concerned.
+func (c *TelegramCore) sendQueued(entries []chanEntry) {
+	errs := make([]error, len(entries))
+	if err := c.blocked(); err != nil {
+		for i, e := range entries {
+			c.telegramClient.observer.failed(e.entry.Level, err)
+			errs[i] = err
+		}
+	} else {
+		errs = c.telegramClient.sendBatch(entries)
+		c.record(errors.Join(errs...))
+	}
+	for i, e := range entries {
+		if errs[i] != nil {
+			c.forgetIdempotencyKey(e.entry, e.fields)
+		}
+		c.backgroundError(c.writeFallback(e.entry, e.fields, errs[i]))
+	}
+}
+
+// blocked returns the reason why no message can be sent right now (if any):
+// the bot is unauthorized or the circuit breaker is open
+func (c *TelegramCore) blocked() error {
+	if c.unauthorized.Load() {
+		return ErrUnauthorized
+	}
+	if c.breaker != nil && !c.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record updates the circuit breaker (if any) and the bot authorization with the result of a delivery
+func (c *TelegramCore) record(err error) {
+	if c.breaker != nil {
+		c.breaker.record(err)
+	}
+	if isUnauthorizedError(err) && c.unauthorized.CompareAndSwap(false, true) {
+		c.telegramClient.handleError(fmt.Errorf("%w: %v", ErrUnauthorized, err)) // reported once until re-enabled
+	}
+}
+
+// forgetIdempotencyKey removes the idempotency key (if any) of an entry dropped or failed to be sent after being
+// checked, so an identical entry isn't suppressed without this one ever reaching the chats
+func (c *TelegramCore) forgetIdempotencyKey(entry zapcore.Entry, fields []zapcore.Field) {
+	if c.idempotencyStore == nil {
+		return
+	}
+	if err := c.idempotencyStore.Delete(idempotencyKey(entry, fields)); err != nil {
+		c.telegramClient.handleError(fmt.Errorf("failed to delete idempotency key: %w", err))
+	}
+}
+
+// writeFallback writes the entry to the fallback core (if any and enabled for its level) when it failed to be sent,
+// returning the delivery error
+func (c *TelegramCore) writeFallback(entry zapcore.Entry, fields []zapcore.Field, err error) error {
+	if err != nil && c.fallback != nil && c.fallback.Enabled(entry.Level) {
+		if fallbackErr := c.fallback.Write(entry, fields); fallbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to write entry to fallback core: %w", fallbackErr))
+		}
+	}
+	return err
+}
+
+// consumeEntriesQueue sends all the entries (messages) in the queue to telegram at the given interval
+func (h *TelegramCore) consumeEntriesQueue(ctx context.Context) error {
+	timer := time.NewTimer(h.nextQueueInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			h.flushOnce()
+			timer.Reset(h.nextQueueInterval())
 		case <-ctx.Done():
-			h.handleNewQueueEntries()
+			h.flushOnce()
 			return ctx.Err()
 		}
 	}
 }
 
+// nextQueueInterval returns the queue interval randomized by the queue jitter (if any)
+func (h *TelegramCore) nextQueueInterval() time.Duration {
+	if h.queueJitter == 0 {
+		return h.intervalQueue
+	}
+	jitter := (rand.Float64()*2 - 1) * h.queueJitter // [-jitter, +jitter)
+	return time.Duration(float64(h.intervalQueue) * (1 + jitter))
+}
+
+// flushOnce sends all the entries in the queue synchronously, it's the queue consumer tick (E.g: for WithManualQueue)
+func (h *TelegramCore) flushOnce() {
+	h.handleNewQueueEntries()
+}
+
 // handleNewQueueEntries send all new message entries in queue to telegram
-func (h TelegramCore) handleNewQueueEntries() {
-	for len(h.entriesChan) > 0 {
-		chanEntry := <-h.entriesChan
-		_ = h.telegramClient.sendMessage(chanEntry.entry, chanEntry.fields)
+func (h *TelegramCore) handleNewQueueEntries() {
+	var batch []chanEntry // entries sent together once the queue is drained (queue batching)
+	send := func(e chanEntry) {
+		if h.queueBatch {
+			batch = append(batch, e)
+			return
+		}
+		h.backgroundError(h.send(e.entry, e.fields))
+	}
+	var last *chanEntry // entry waiting to be sent, in case the next ones are identical (queue coalescing)
+	repeated := 0
+	flush := func() {
+		if last == nil {
+			return
+		}
+		if repeated > 1 {
+			last.entry.Message = fmt.Sprintf("%s ×%d", last.entry.Message, repeated)
+		}
+		send(*last)
+		last = nil
+	}
+	defer func() {
+		flush()
+		if len(batch) > 0 {
+			h.sendQueued(batch)
+		}
+	}()
+	for {
+		select {
+		case chanEntry := <-h.entriesChan:
+			if chanEntry.ctx != nil && chanEntry.ctx.Err() != nil {
+				h.drop(chanEntry.entry.Level, DropReasonStale) // the logged operation is already over
+				continue
+			}
+			if !h.queueCoalesce {
+				send(chanEntry)
+				continue
+			}
+			if last != nil && isSameEntry(*last, chanEntry) {
+				repeated++
+				continue
+			}
+			flush()
+			last, repeated = &chanEntry, 1
+		default:
+			return // queue is empty (it may be consumed concurrently by Sync and the queue consumer)
+		}
+	}
+}
+
+// isSameEntry reports whether both queued entries have the same level, logger name, message and fields (time aside)
+func isSameEntry(a, b chanEntry) bool {
+	if a.entry.Level != b.entry.Level || a.entry.LoggerName != b.entry.LoggerName || a.entry.Message != b.entry.Message ||
+		len(a.fields) != len(b.fields) {
+		return false
+	}
+	for i := range a.fields {
+		if !a.fields[i].Equals(b.fields[i]) {
+			return false
+		}
 	}
+	return true
 }
 
-// getLevelThreshold returns all levels equal and above the given level
+// getLevelThreshold returns all levels equal and above the given level, which may be a custom one (E.g: below debug)
 func getLevelThreshold(l zapcore.Level) []zapcore.Level {
-	for i := range AllLevels {
-		if AllLevels[i] == l {
-			return AllLevels[i:]
+	levels := []zapcore.Level{}
+	for _, level := range AllLevels {
+		if level >= l {
+			levels = append(levels, level)
 		}
 	}
-	return []zapcore.Level{}
+	return levels
 }
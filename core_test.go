@@ -0,0 +1,293 @@
+package zap2telegram
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/goleak"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFlushWhileLogging(t *testing.T) {
+	core, err := NewTelegramCore("", []int64{1}, WithDryRun(func(int64, string) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					logger.Error("steady")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_ = core.(*TelegramCore).Flush(ctx) // mustn't panic, it may time out under steady logging
+		cancel()
+	}
+	close(stop)
+	wg.Wait()
+	if err := logger.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+}
+
+func TestTapPanicDoesNotCrash(t *testing.T) {
+	core, err := NewTelegramCore("", []int64{1, 2},
+		WithDryRun(func(int64, string) {}),
+		WithTap(func(int64, zapcore.Level, string) { panic("boom") }),
+		WithErrorOutput(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+	logger.Error("first")
+	logger.Error("second") // the chat workers keep working after a panic
+	if err := logger.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if err := core.(*TelegramCore).LastError(); err == nil || !strings.Contains(err.Error(), "panic sending message: boom") {
+		t.Fatalf("LastError() = %v, want the tap panic", err)
+	}
+}
+
+func TestFailingOptionAfterQueueLeaksNothing(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent()) // the goroutines of the previous tests aren't this one's
+	_, err := NewTelegramCore("", []int64{1}, WithDryRun(func(int64, string) {}),
+		WithQueue(context.Background(), time.Millisecond, 10), WithMaxInflight(0))
+	if !errors.Is(err, ErrMaxInflight) {
+		t.Fatalf("NewTelegramCore() error = %v, want %v", err, ErrMaxInflight)
+	}
+}
+
+func TestGetLevelThreshold(t *testing.T) {
+	tests := []struct {
+		name  string
+		level zapcore.Level
+		want  []zapcore.Level
+	}{
+		{"debug", zapcore.DebugLevel, AllLevels[:]},
+		{"warn", zapcore.WarnLevel, []zapcore.Level{zapcore.WarnLevel, zapcore.ErrorLevel, zapcore.PanicLevel, zapcore.FatalLevel}},
+		{"panic before fatal", zapcore.PanicLevel, []zapcore.Level{zapcore.PanicLevel, zapcore.FatalLevel}},
+		{"fatal", zapcore.FatalLevel, []zapcore.Level{zapcore.FatalLevel}},
+		{"dpanic", zapcore.DPanicLevel, []zapcore.Level{zapcore.PanicLevel, zapcore.FatalLevel}},
+		{"custom below debug", zapcore.Level(-4), AllLevels[:]},
+		{"custom above fatal", zapcore.Level(10), []zapcore.Level{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getLevelThreshold(tt.level); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getLevelThreshold(%v) = %v, want %v", tt.level, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackLevel(t *testing.T) {
+	f := newFakeTelegram(t)
+	f.fail = func(r fakeRequest) *tgbotapi.Error {
+		if r.method == "sendMessage" {
+			return badRequest("Bad Request: chat not found")
+		}
+		return nil
+	}
+	fallback, logs := observer.New(zapcore.ErrorLevel)
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithLevel(zapcore.InfoLevel), WithFallbackCore(fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []zapcore.Level{zapcore.InfoLevel, zapcore.ErrorLevel} {
+		if err := core.Write(zapcore.Entry{Level: l, Message: l.String()}, nil); err == nil {
+			t.Errorf("Write(%s) succeeded, want the delivery error", l)
+		}
+	}
+	if got := logs.All(); len(got) != 1 || got[0].Level != zapcore.ErrorLevel {
+		t.Errorf("fallback entries = %v, want only the error one", got)
+	}
+}
+
+func TestConstructionFallbackLevel(t *testing.T) {
+	f := newFakeTelegram(t)
+	f.fail = func(r fakeRequest) *tgbotapi.Error {
+		return badRequest("Bad Request: not available")
+	}
+	fallback, logs := observer.New(zapcore.ErrorLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(), WithContext(ctx),
+		WithLevel(zapcore.InfoLevel), WithConstructionFallback(fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if core.(*TelegramCore).Ready() {
+		t.Fatal("Ready() = true, want false")
+	}
+	for _, l := range []zapcore.Level{zapcore.InfoLevel, zapcore.ErrorLevel} {
+		if err := core.Write(zapcore.Entry{Level: l, Message: l.String()}, nil); err != nil {
+			t.Errorf("Write(%s) = %v", l, err)
+		}
+	}
+	if got := logs.All(); len(got) != 1 || got[0].Level != zapcore.ErrorLevel {
+		t.Errorf("construction fallback entries = %v, want only the error one", got)
+	}
+}
+
+func TestWriteWithoutChats(t *testing.T) {
+	var texts []string
+	core, err := NewTelegramCore("", nil, WithoutAsyncOpt(), WithAllowEmptyChats(), WithSequenceNumbers(),
+		WithDryRun(func(_ int64, text string) { texts = append(texts, text) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := core.(*TelegramCore)
+	if err := tc.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "nobody"}, nil); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if len(texts) != 0 {
+		t.Fatalf("sent %q without chats", texts)
+	}
+	if err := tc.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "chat field"}, []zapcore.Field{ChatField(2)}); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	tc.AddChatID(1)
+	if err := tc.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "default chat"}, nil); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	// the entry written without chats isn't numbered
+	if len(texts) != 2 || !strings.Contains(texts[0], "#1") || !strings.Contains(texts[1], "#2") {
+		t.Errorf("sent %q, want the entries numbered #1 and #2", texts)
+	}
+}
+
+func TestIdempotencyKeyOfUndeliveredEntry(t *testing.T) {
+	f := newFakeTelegram(t)
+	failures := 1
+	f.fail = func(r fakeRequest) *tgbotapi.Error {
+		if r.method == "sendMessage" && failures > 0 {
+			failures--
+			return badRequest("Bad Request: chat not found")
+		}
+		return nil
+	}
+	store := NewMemoryIdempotencyStore()
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithIdempotencyStore(store, time.Hour), WithGlobalThrottle(2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := zapcore.Entry{Level: zapcore.ErrorLevel, Message: "failed"}
+	if err := core.Write(failed, nil); err == nil {
+		t.Fatal("Write() succeeded, want the delivery error")
+	}
+	if err := core.Write(failed, nil); err != nil { // not suppressed, the first one never reached the chat
+		t.Fatalf("Write() = %v", err)
+	}
+	throttled := zapcore.Entry{Level: zapcore.ErrorLevel, Message: "throttled"}
+	if err := core.Write(throttled, nil); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(f.sent("sendMessage")); got != 3 { // failed, sent and the throttle notice
+		t.Errorf("sent %d messages, want 3", got)
+	}
+	if added, _ := store.SetIfAbsent(idempotencyKey(throttled, nil), time.Hour); !added {
+		t.Error("the key of the throttled entry is stored")
+	}
+}
+
+func TestFormatterPanic(t *testing.T) {
+	boom := WithFormatter(func(e zapcore.Entry, _ []zapcore.Field) string {
+		if e.Message == "boom" {
+			panic("boom")
+		}
+		return e.Message
+	})
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"sync", []Option{WithoutAsyncOpt()}},
+		{"queue", []Option{WithQueue(context.Background(), time.Hour, 10)}},
+		{"queue batching", []Option{WithQueue(context.Background(), time.Hour, 10), WithQueueBatching()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeTelegram(t)
+			var mu sync.Mutex
+			var handled []error
+			opts := append([]Option{WithAPIEndpoint(f.endpoint()), boom, WithErrorHandler(func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				handled = append(handled, err)
+			})}, tt.opts...)
+			core, err := NewTelegramCore("token", []int64{1}, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs := []error{
+				core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "boom"}, nil),
+				core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "fine"}, nil),
+			}
+			if err := core.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			errs = append(errs, handled...)
+			mu.Unlock()
+			if err := errors.Join(errs...); err == nil || !strings.Contains(err.Error(), "panic sending message: boom") {
+				t.Errorf("errors = %v, want the panic", err)
+			}
+			if sent := f.sent("sendMessage"); len(sent) != 1 || sent[0]["text"] != "fine" {
+				t.Errorf("sent %v, want only the fine entry", sent)
+			}
+		})
+	}
+}
+
+func TestQueueInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := NewTelegramCore("", []int64{1}, WithDryRun(func(int64, string) {}),
+			WithQueue(context.Background(), interval, 10)); !errors.Is(err, ErrQueueInterval) {
+			t.Errorf("NewTelegramCore(interval %s) = %v, want %v", interval, err, ErrQueueInterval)
+		}
+	}
+}
+
+func TestQueueAfterManualQueue(t *testing.T) {
+	sent := make(chan string, 1)
+	core, err := NewTelegramCore("", []int64{1}, WithDryRun(func(_ int64, text string) { sent <- text }),
+		WithManualQueue(10), WithQueue(context.Background(), time.Millisecond, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "queued"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("the queue isn't consumed without flushing it")
+	}
+}
@@ -0,0 +1,250 @@
+package zap2telegram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Reserved field keys. Fields with these keys are handled by the core and never rendered in the message.
+// They're all zapcore.SkipType fields so they're ignored by any other core too.
+const (
+	chatFieldKey   = "zap2telegram.chat"
+	silentFieldKey = "zap2telegram.silent"
+	replyToKey     = "zap2telegram.reply_to"
+	contextKey     = "zap2telegram.context"
+	photoKey       = "zap2telegram.photo"
+	scheduleKey    = "zap2telegram.schedule"
+	sequenceKey    = "zap2telegram.sequence" // internal, see WithSequenceNumbers
+)
+
+// entryOverrides are the per-entry settings set through the reserved fields
+type entryOverrides struct {
+	chatIDs    []int64         // send the entry to these chats instead of the default ones
+	silent     bool            // always send the entry without notification
+	replyTo    int             // send the entry as a reply to this message id
+	ctx        context.Context // context of the operation that logged the entry
+	threadID   int             // send the entry to this forum topic (message thread id), see WithThreadResolver
+	topicValue string          // send the entry to the forum topic of this field value, see WithThreadByField
+	photo      *photo          // send the entry as a photo
+	notify     bool            // always send the entry with notification (unless silent), see WithNotifyWhen
+	sequence   uint64          // sequence number of the entry (if any), see WithSequenceNumbers
+}
+
+// ChatField routes the log entry to the given chat id instead of the default ones.
+// Use it multiple times to route the entry to several chats.
+func ChatField(id int64) zap.Field {
+	return zap.Field{Key: chatFieldKey, Type: zapcore.SkipType, Integer: id}
+}
+
+// SilentField sends the log entry without notification, it takes precedence over any other notification setting
+// (E.g: an entry with this field is sent silently even if its level is enabled through WithNotificationOn)
+func SilentField() zap.Field {
+	return zap.Field{Key: silentFieldKey, Type: zapcore.SkipType}
+}
+
+// ReplyTo sends the log entry as a reply to the given message id so related alerts are threaded together.
+// Message ids are chat specific (see TelegramCore.LastMessageIDs), so it's usually combined with ChatField.
+// The entry is still sent (not as a reply) if the message no longer exists.
+func ReplyTo(messageID int) zap.Field {
+	return zap.Field{Key: replyToKey, Type: zapcore.SkipType, Integer: int64(messageID)}
+}
+
+// ContextField attaches the context of the operation being logged to the log entry, usually through a request scoped
+// logger (E.g: logger.With(zap2telegram.ContextField(r.Context()))). Queued entries whose context is done by the time
+// the queue is flushed are dropped instead of sending stale alerts, and the synchronous writes return as soon as
+// the context is done instead of blocking on a slow Telegram request.
+func ContextField(ctx context.Context) zap.Field {
+	return zap.Field{Key: contextKey, Type: zapcore.SkipType, Interface: ctx}
+}
+
+// photo is an image attached to a log entry through Photo
+type photo struct {
+	once    sync.Once
+	reader  io.Reader
+	caption string
+	data    []byte // image read from the reader, so it can be sent to several chats
+	err     error  // error reading the image
+}
+
+// bytes returns the image, the reader is only read once
+func (p *photo) bytes() ([]byte, error) {
+	p.once.Do(func() {
+		p.data, p.err = io.ReadAll(p.reader)
+	})
+	return p.data, p.err
+}
+
+// Photo sends the log entry as a photo (E.g: a rendered latency graph) with the formatted message as its caption,
+// or the given caption when it's not empty. Captions longer than the Telegram caption limit are replaced by
+// the entry level and message.
+func Photo(reader io.Reader, caption string) zap.Field {
+	return zap.Field{Key: photoKey, Type: zapcore.SkipType, Interface: &photo{reader: reader, caption: caption}}
+}
+
+// isEmptyEntry reports whether the entry has neither message, regular fields nor photo
+func isEmptyEntry(e zapcore.Entry, fields []zapcore.Field) bool {
+	if e.Message != "" {
+		return false
+	}
+	for _, f := range fields {
+		if !isReservedField(f) || f.Key == photoKey {
+			return false
+		}
+	}
+	return true
+}
+
+// sequenceField returns the internal field carrying the sequence number of an entry
+func sequenceField(n uint64) zap.Field {
+	return zap.Field{Key: sequenceKey, Type: zapcore.SkipType, Integer: int64(n)}
+}
+
+// isReservedField reports whether f is one of the reserved fields
+func isReservedField(f zapcore.Field) bool {
+	if f.Type != zapcore.SkipType {
+		return false
+	}
+	switch f.Key {
+	case chatFieldKey, silentFieldKey, replyToKey, contextKey, photoKey, scheduleKey, sequenceKey:
+		return true
+	}
+	return false
+}
+
+// extractReservedFields returns the entry overrides set through the reserved fields and the remaining regular fields
+func extractReservedFields(fields []zapcore.Field) (entryOverrides, []zapcore.Field) {
+	var o entryOverrides
+	reserved := 0
+	for _, f := range fields {
+		if !isReservedField(f) {
+			continue
+		}
+		reserved++
+		switch f.Key {
+		case chatFieldKey:
+			if !containsChatID(o.chatIDs, f.Integer) { // E.g: the same ChatField inherited and set on the entry
+				o.chatIDs = append(o.chatIDs, f.Integer)
+			}
+		case silentFieldKey:
+			o.silent = true
+		case replyToKey:
+			o.replyTo = int(f.Integer)
+		case contextKey:
+			if o.ctx == nil { // the entry fields come before the inherited ones
+				o.ctx, _ = f.Interface.(context.Context)
+			}
+		case sequenceKey:
+			o.sequence = uint64(f.Integer)
+		case photoKey:
+			if o.photo == nil {
+				o.photo, _ = f.Interface.(*photo)
+			}
+		}
+	}
+	if reserved == 0 {
+		return o, fields
+	}
+	regular := make([]zapcore.Field, 0, len(fields)-reserved)
+	for _, f := range fields {
+		if !isReservedField(f) {
+			regular = append(regular, f)
+		}
+	}
+	return o, regular
+}
+
+// containsChatID reports whether the chat id is in the list
+func containsChatID(chatIDs []int64, chatID int64) bool {
+	for _, id := range chatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeFields returns the entry fields followed by the inherited ones without duplicated keys: an entry field
+// takes precedence over an inherited one, and the last field wins among the entry or inherited fields
+// (E.g: the field added by a child logger With over the one added by its parent).
+// Reserved fields are never deduplicated and nothing is deduplicated when there are namespaces, since the same key
+// may be used in different namespaces.
+func mergeFields(fields, inherited []zapcore.Field) []zapcore.Field {
+	merged := append(fields[:len(fields):len(fields)], inherited...)
+	if len(inherited) == 0 {
+		return merged
+	}
+	winners := make(map[string]int, len(merged))
+	for i, f := range merged {
+		switch {
+		case f.Type == zapcore.NamespaceType:
+			return merged
+		case f.Type == zapcore.SkipType:
+			continue
+		}
+		if w, ok := winners[f.Key]; ok && w < len(fields) && i >= len(fields) {
+			continue // the entry field wins
+		}
+		winners[f.Key] = i
+	}
+	if len(winners) == len(merged) {
+		return merged // no duplicates nor reserved fields
+	}
+	deduped := make([]zapcore.Field, 0, len(merged))
+	for i, f := range merged {
+		if w, ok := winners[f.Key]; f.Type == zapcore.SkipType || (ok && w == i) {
+			deduped = append(deduped, f)
+		}
+	}
+	return deduped
+}
+
+// requiredField is a field required for an entry to be sent, see WithRequireField
+type requiredField struct {
+	key    string
+	values []string // accepted values (any if empty)
+}
+
+// matches reports whether the fields contain the required field with one of the accepted values
+func (r requiredField) matches(fields []zapcore.Field) bool {
+	for _, f := range fields {
+		if f.Key != r.key || f.Type == zapcore.SkipType {
+			continue
+		}
+		if len(r.values) == 0 {
+			return true
+		}
+		value := fieldValueString(f)
+		for _, v := range r.values {
+			if value == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fieldValueString returns the field value as a string (E.g: "true" for zap.Bool("page", true))
+func fieldValueString(f zapcore.Field) string {
+	if f.Type == zapcore.StringType {
+		return f.String
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+	return fmt.Sprint(enc.Fields[f.Key])
+}
+
+// contextFromFields returns the context set through ContextField (if any)
+func contextFromFields(fields []zapcore.Field) context.Context {
+	for _, f := range fields {
+		if f.Key == contextKey && f.Type == zapcore.SkipType {
+			ctx, _ := f.Interface.(context.Context)
+			return ctx
+		}
+	}
+	return nil
+}
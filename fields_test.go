@@ -0,0 +1,115 @@
+package zap2telegram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMergeFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    []zapcore.Field
+		inherited []zapcore.Field
+		want      []zapcore.Field
+	}{
+		{
+			name:   "no inherited fields",
+			fields: []zapcore.Field{zap.String("user", "a"), zap.String("user", "b")},
+			want:   []zapcore.Field{zap.String("user", "a"), zap.String("user", "b")},
+		},
+		{
+			name:      "child With wins over parent With",
+			inherited: []zapcore.Field{zap.String("user", "a"), zap.String("user", "b")},
+			fields:    []zapcore.Field{zap.Int("n", 1)},
+			want:      []zapcore.Field{zap.Int("n", 1), zap.String("user", "b")},
+		},
+		{
+			name:      "entry field wins over inherited ones",
+			inherited: []zapcore.Field{zap.String("user", "a"), zap.String("user", "b")},
+			fields:    []zapcore.Field{zap.String("user", "c")},
+			want:      []zapcore.Field{zap.String("user", "c")},
+		},
+		{
+			name:      "last entry field wins",
+			inherited: []zapcore.Field{zap.String("user", "a")},
+			fields:    []zapcore.Field{zap.String("user", "b"), zap.String("user", "c")},
+			want:      []zapcore.Field{zap.String("user", "c")},
+		},
+		{
+			name:      "reserved fields are kept",
+			inherited: []zapcore.Field{ChatField(1), zap.String("user", "a")},
+			fields:    []zapcore.Field{ChatField(2), zap.String("user", "b")},
+			want:      []zapcore.Field{ChatField(2), zap.String("user", "b"), ChatField(1)},
+		},
+		{
+			name:      "namespaces bail out",
+			inherited: []zapcore.Field{zap.Namespace("req"), zap.String("user", "a")},
+			fields:    []zapcore.Field{zap.String("user", "b")},
+			want:      []zapcore.Field{zap.String("user", "b"), zap.Namespace("req"), zap.String("user", "a")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeFields(tt.fields, tt.inherited); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithInheritedFieldOverride(t *testing.T) {
+	core, texts := dryRunCore(t, WithCompactFormat())
+	logger := zap.New(core).With(zap.String("user", "a")).With(zap.String("user", "b"))
+	logger.Error("hello")
+	if want := []string{"hello user=b"}; !reflect.DeepEqual(*texts, want) {
+		t.Errorf("sent %q, want %q", *texts, want)
+	}
+}
+
+func TestIsEmptyEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		fields  []zapcore.Field
+		want    bool
+	}{
+		{"empty everything", "", nil, true},
+		{"only reserved fields", "", []zapcore.Field{ChatField(1), SilentField()}, true},
+		{"empty message with fields", "", []zapcore.Field{zap.Int("n", 1)}, false},
+		{"empty message with photo", "", []zapcore.Field{Photo(nil, "")}, false},
+		{"message", "hello", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isEmptyEntry(zapcore.Entry{Message: tt.message}, tt.fields); got != tt.want {
+				t.Errorf("isEmptyEntry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyMessage(t *testing.T) {
+	core, texts := dryRunCore(t, WithoutLoggerName(), WithTimeLayout("15:04"))
+	e := zapcore.Entry{Level: zapcore.ErrorLevel, Time: time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)}
+	if err := core.Write(e, []zapcore.Field{zap.Int("n", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"09:30\nerror\nn=1"}; !reflect.DeepEqual(*texts, want) {
+		t.Errorf("sent %q, want %q", *texts, want)
+	}
+}
+
+func TestSkipEmpty(t *testing.T) {
+	core, texts := dryRunCore(t, WithSkipEmpty(), WithCompactFormat())
+	logger := zap.New(core)
+	logger.Error("")
+	logger.Error("", ChatField(1))
+	logger.Error("", zap.Int("n", 1))
+	if want := []string{"n=1"}; !reflect.DeepEqual(*texts, want) {
+		t.Errorf("sent %q, want %q", *texts, want)
+	}
+}
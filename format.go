@@ -0,0 +1,430 @@
+package zap2telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+// bufferPool reuses the default formatter buffers to cut allocations under load
+// (a strings.Builder can't be reused once its String method has been called)
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// renderLevel returns the level rendered by the default formatter
+func (c *telegramClient) renderLevel(l zapcore.Level, parseMode string) string {
+	if c.levelText == nil {
+		return l.String()
+	}
+	return c.escape(parseMode, c.levelText(l))
+}
+
+// renderedField is a field rendered by the default formatter
+type renderedField struct {
+	key     string
+	value   string
+	spoiler bool // render the value as a spoiler
+}
+
+// spoilerText is a field value rendered as a spoiler, see Spoiler
+type spoilerText string
+
+func (s spoilerText) String() string {
+	return string(s)
+}
+
+// Spoiler wraps a field value so the default formatter renders it hidden behind a tap-to-reveal spoiler
+// under the MarkdownV2 and HTML parse modes (E.g: zap.Stringer("account_id", zap2telegram.Spoiler("1234"))).
+// The value is rendered as is without parse mode, inside code blocks and by any other core.
+func Spoiler(s string) fmt.Stringer {
+	return spoilerText(s)
+}
+
+// ━━━━━━━━━━
+// #1423
+// Logger: zap2telegram
+// Host: web-1
+// 11:25:59 01.01.2007
+// info
+// Hello bar
+// user_id=12345
+func (c *telegramClient) defaultFormat(e zapcore.Entry, o entryOverrides, fields []zapcore.Field, parseMode string) string {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+	if c.severityDivider != "" && e.Level >= c.severityDividerLevel {
+		buf.WriteString(c.escape(parseMode, c.severityDivider))
+		buf.WriteByte('\n')
+	}
+	if o.sequence != 0 {
+		buf.WriteString(c.escape(parseMode, "#"+strconv.FormatUint(o.sequence, 10)))
+		if c.compactFormat {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte('\n')
+		}
+	}
+	if c.compactFormat {
+		c.writeCompactDefaultFormat(buf, e, fields, parseMode)
+		return buf.String()
+	}
+	if !c.withoutLoggerName {
+		loggerName := c.defaultLoggerName
+		if e.LoggerName != "" {
+			loggerName = e.LoggerName
+		}
+		buf.WriteString("Logger: ")
+		buf.WriteString(c.escape(parseMode, loggerName))
+		buf.WriteByte('\n')
+	}
+	if c.hostname != "" {
+		buf.WriteString("Host: ")
+		buf.WriteString(c.escape(parseMode, c.hostname))
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(c.escape(parseMode, c.formatTime(e.Time)))
+	buf.WriteByte('\n')
+	buf.WriteString(c.renderLevel(e.Level, parseMode))
+	if e.Message != "" { // the message line is omitted rather than left blank
+		buf.WriteByte('\n')
+		buf.WriteString(c.escapeEntryMessage(parseMode, e.Message))
+	}
+	if c.fieldsAsCodeBlock {
+		if rendered := c.renderFields(fields); len(rendered) > 0 {
+			buf.WriteByte('\n')
+			c.writeFieldsCodeBlock(buf, rendered, parseMode)
+		}
+		return buf.String()
+	}
+	for i, f := range c.renderFields(fields) {
+		if i == 0 {
+			buf.WriteByte('\n')
+		} else {
+			c.writeFieldSeparator(buf, "\n", parseMode)
+		}
+		c.writeField(buf, f, parseMode)
+	}
+	return buf.String()
+}
+
+// #1423 [web-1] Hello bar user_id=12345
+func (c *telegramClient) writeCompactDefaultFormat(buf *bytes.Buffer, e zapcore.Entry, fields []zapcore.Field, parseMode string) {
+	if c.hostname != "" {
+		buf.WriteString(c.escape(parseMode, "["+c.hostname+"] "))
+	}
+	buf.WriteString(c.escapeEntryMessage(parseMode, e.Message))
+	for i, f := range c.renderFields(fields) {
+		if i == 0 {
+			if e.Message != "" {
+				buf.WriteByte(' ')
+			}
+		} else {
+			c.writeFieldSeparator(buf, " ", parseMode)
+		}
+		c.writeField(buf, f, parseMode)
+	}
+}
+
+// writeFieldSeparator writes the field separator (if any) or the given default one
+func (c *telegramClient) writeFieldSeparator(buf *bytes.Buffer, defaultSeparator, parseMode string) {
+	if c.fieldSeparator == nil {
+		buf.WriteString(defaultSeparator)
+		return
+	}
+	buf.WriteString(c.escape(parseMode, *c.fieldSeparator))
+}
+
+// writeField writes a rendered field as key=value
+func (c *telegramClient) writeField(buf *bytes.Buffer, f renderedField, parseMode string) {
+	buf.WriteString(c.escape(parseMode, f.key))
+	buf.WriteByte('=')
+	value := c.escape(parseMode, f.value)
+	if f.spoiler && c.codeBlock == nil {
+		switch parseMode {
+		case tgbotapi.ModeMarkdownV2:
+			value = "||" + value + "||"
+		case tgbotapi.ModeHTML:
+			value = "<tg-spoiler>" + value + "</tg-spoiler>"
+		}
+	}
+	buf.WriteString(value)
+}
+
+// fieldsFenceEscaper replaces the backticks breaking a legacy markdown code block, which has no way to escape them
+var fieldsFenceEscaper = strings.NewReplacer("`", "'")
+
+// writeFieldsCodeBlock writes the rendered fields as a code block of aligned "key: value" lines so they're easy
+// to scan on a phone. The lines are written without code block when there's no parse mode or the whole
+// message is already wrapped in a code block.
+func (c *telegramClient) writeFieldsCodeBlock(buf *bytes.Buffer, fields []renderedField, parseMode string) {
+	width := 0
+	for _, f := range fields {
+		if n := utf8.RuneCountInString(f.key); n > width {
+			width = n
+		}
+	}
+	var lines strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			lines.WriteByte('\n')
+		}
+		lines.WriteString(f.key)
+		lines.WriteString(": ")
+		lines.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(f.key)))
+		lines.WriteString(f.value)
+	}
+	if c.codeBlock != nil {
+		parseMode = "" // already in a code block
+	}
+	switch parseMode {
+	case tgbotapi.ModeMarkdownV2:
+		buf.WriteString("```\n" + codeBlockEscaper.Replace(lines.String()) + "\n```")
+	case tgbotapi.ModeMarkdown:
+		buf.WriteString("```\n" + fieldsFenceEscaper.Replace(lines.String()) + "\n```")
+	case tgbotapi.ModeHTML:
+		buf.WriteString("<pre>" + escapeText(tgbotapi.ModeHTML, lines.String()) + "</pre>")
+	default:
+		buf.WriteString(lines.String())
+	}
+}
+
+// renderFields renders the fields values, a single field may be rendered as multiple ones (E.g: zap.Object)
+func (c *telegramClient) renderFields(fields []zapcore.Field) []renderedField {
+	rendered := make([]renderedField, 0, len(fields))
+	for _, f := range fields {
+		if c.fieldValueFormatter != nil {
+			if value, ok := c.fieldValueFormatter(f); ok {
+				rendered = append(rendered, renderedField{key: f.Key, value: value})
+				continue
+			}
+		}
+		if spoiler, ok := f.Interface.(spoilerText); ok && f.Type == zapcore.StringerType {
+			rendered = append(rendered, renderedField{key: f.Key, value: string(spoiler), spoiler: true})
+			continue
+		}
+		if f.Type == zapcore.ErrorType {
+			if err, ok := f.Interface.(error); ok && err != nil {
+				rendered = append(rendered, renderedField{key: f.Key, value: c.renderError(err)})
+				continue
+			}
+		}
+		if value, ok := c.renderSimpleField(f); ok {
+			rendered = append(rendered, renderedField{key: f.Key, value: value})
+			continue
+		}
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		keys := make([]string, 0, len(enc.Fields))
+		for k := range enc.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rendered = append(rendered, renderedField{key: k, value: fmt.Sprintf("%+v", enc.Fields[k])})
+		}
+	}
+	if c.fieldOrder != nil {
+		c.sortFields(rendered)
+	}
+	return rendered
+}
+
+// sortFields sorts the rendered fields by the field order: the pinned keys first, in the given order,
+// followed by the rest of keys alphabetically
+func (c *telegramClient) sortFields(fields []renderedField) {
+	sort.SliceStable(fields, func(i, j int) bool {
+		pi, iPinned := c.fieldOrder[fields[i].key]
+		pj, jPinned := c.fieldOrder[fields[j].key]
+		switch {
+		case iPinned && jPinned:
+			return pi < pj
+		case iPinned != jPinned:
+			return iPinned
+		}
+		return fields[i].key < fields[j].key
+	})
+}
+
+// renderSimpleField renders the most common field types without going through an encoder,
+// durations and times are rendered in a human-friendly form
+func (c *telegramClient) renderSimpleField(f zapcore.Field) (string, bool) {
+	switch f.Type {
+	case zapcore.StringType:
+		return f.String, true
+	case zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type:
+		if c.numberFormatter != nil {
+			return c.numberFormatter(f.Integer), true
+		}
+		return strconv.FormatInt(f.Integer, 10), true
+	case zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type:
+		if c.numberFormatter != nil && f.Integer >= 0 { // uint64 values above math.MaxInt64 are rendered as is
+			return c.numberFormatter(f.Integer), true
+		}
+		return strconv.FormatUint(uint64(f.Integer), 10), true
+	case zapcore.UintptrType:
+		return strconv.FormatUint(uint64(f.Integer), 10), true
+	case zapcore.BoolType:
+		return strconv.FormatBool(f.Integer == 1), true
+	case zapcore.DurationType:
+		return time.Duration(f.Integer).String(), true
+	case zapcore.TimeType:
+		t := time.Unix(0, f.Integer)
+		if loc, ok := f.Interface.(*time.Location); ok {
+			t = t.In(loc)
+		}
+		return c.formatTime(t), true
+	case zapcore.TimeFullType:
+		if t, ok := f.Interface.(time.Time); ok {
+			return c.formatTime(t), true
+		}
+	}
+	return "", false
+}
+
+// fieldsJSON returns the fields encoded as pretty-printed JSON
+func fieldsJSON(fields []zapcore.Field) ([]byte, error) {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return json.MarshalIndent(enc.Fields, "", "  ")
+}
+
+// jsonFormat formats the entry as a single line JSON object, see WithJSONFormat
+func jsonFormat(e zapcore.Entry, fields []zapcore.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	entry := map[string]interface{}{
+		"level":  e.Level.String(),
+		"time":   e.Time.Format(time.RFC3339Nano),
+		"msg":    e.Message,
+		"fields": enc.Fields,
+	}
+	if e.LoggerName != "" {
+		entry["logger"] = e.LoggerName
+	}
+	if e.Caller.Defined {
+		entry["caller"] = e.Caller.TrimmedPath()
+	}
+	b, err := json.Marshal(entry)
+	if err != nil { // E.g: a field value which can't be encoded as JSON
+		delete(entry, "fields")
+		entry["error"] = err.Error()
+		b, _ = json.Marshal(entry)
+	}
+	return string(b)
+}
+
+// groupThousands renders n grouping its digits by thousands with commas (E.g: 1,234,567)
+func groupThousands(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= 3 {
+		return sign + digits
+	}
+	var b strings.Builder
+	b.WriteString(sign)
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > len(sign) {
+			b.WriteByte(',')
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
+
+// formatTime formats t with the configured time layout (if any)
+func (c *telegramClient) formatTime(t time.Time) string {
+	if c.timeLayout == "" {
+		return t.String()
+	}
+	return t.Format(c.timeLayout)
+}
+
+// renderError renders an error field value, including the verbose error chain (E.g: pkg/errors stacktrace)
+// when the verbose errors option is enabled
+func (c *telegramClient) renderError(err error) string {
+	if c.verboseErrors {
+		switch err.(type) {
+		case fmt.Formatter, interface{ Unwrap() error }:
+			return strings.TrimSpace(fmt.Sprintf("%+v", err))
+		}
+	}
+	return err.Error()
+}
+
+// textEscapers escape the text for each parse mode like tgbotapi.EscapeText, which builds its replacer on every call
+var textEscapers = map[string]*strings.Replacer{
+	tgbotapi.ModeHTML:     strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;"),
+	tgbotapi.ModeMarkdown: strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\["),
+	tgbotapi.ModeMarkdownV2: strings.NewReplacer(
+		"_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "~", "\\~", "`", "\\`", ">", "\\>",
+		"#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!",
+	),
+}
+
+// escapeText escapes the text according to the parse mode (nothing is returned for unknown ones, like tgbotapi.EscapeText)
+func escapeText(parseMode, s string) string {
+	if r, ok := textEscapers[parseMode]; ok {
+		return r.Replace(s)
+	}
+	return ""
+}
+
+// escape escapes s according to the parse mode (if any).
+// Nothing is escaped when the message is wrapped in a code block since the whole block is escaped at once.
+func (c *telegramClient) escape(parseMode, s string) string {
+	if parseMode == "" || c.codeBlock != nil {
+		return s
+	}
+	return escapeText(parseMode, s)
+}
+
+// escapeEntryMessage escapes the entry message according to the parse mode, unless disabled with WithEscapeMessage
+func (c *telegramClient) escapeEntryMessage(parseMode, msg string) string {
+	if !c.escapeMessage {
+		return msg
+	}
+	return c.escape(parseMode, msg)
+}
+
+// codeBlockEscaper escapes the characters breaking a MarkdownV2 code block
+var codeBlockEscaper = strings.NewReplacer("\\", "\\\\", "`", "\\`")
+
+// wrapCodeBlock returns the escaped body along with the code block fences according to the parse mode.
+// The body is returned untouched without fences when there's no parse mode (no way to render a code block).
+func (c *telegramClient) wrapCodeBlock(body, parseMode string) (escaped, open, close string) {
+	lang := *c.codeBlock
+	switch parseMode {
+	case tgbotapi.ModeMarkdownV2:
+		return codeBlockEscaper.Replace(body), "```" + lang + "\n", "\n```"
+	case tgbotapi.ModeMarkdown:
+		// legacy markdown has no way to escape characters inside a code block
+		return body, "```" + lang + "\n", "\n```"
+	case tgbotapi.ModeHTML:
+		if lang == "" {
+			return escapeText(tgbotapi.ModeHTML, body), "<pre>", "</pre>"
+		}
+		return escapeText(tgbotapi.ModeHTML, body), fmt.Sprintf(`<pre><code class="language-%s">`, lang), "</code></pre>"
+	}
+	return body, "", ""
+}
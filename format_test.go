@@ -0,0 +1,81 @@
+package zap2telegram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func BenchmarkDefaultFormat(b *testing.B) {
+	c := newTelegramClient("", nil)
+	e := zapcore.Entry{Level: zapcore.ErrorLevel, Time: time.Now(), LoggerName: "bench", Message: "payment failed"}
+	fields := []zapcore.Field{
+		zap.String("user_id", "12345"),
+		zap.Int("amount", 4200),
+		zap.Error(errors.New("card declined")),
+		zap.Duration("elapsed", 1500*time.Millisecond),
+	}
+	for _, parseMode := range []string{"", "MarkdownV2", "HTML"} {
+		b.Run("parse mode "+parseMode, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = c.defaultFormat(e, entryOverrides{}, fields, parseMode)
+			}
+		})
+		b.Run("fmt.Sprintf baseline parse mode "+parseMode, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = sprintfDefaultFormat(e, fields, parseMode)
+			}
+		})
+	}
+}
+
+// sprintfDefaultFormat is the default formatter as it was before reusing the buffers, the benchmark baseline
+func sprintfDefaultFormat(e zapcore.Entry, fields []zapcore.Field, parseMode string) string {
+	msg := fmt.Sprintf("Logger: %s\n%s\n%s\n%s", e.LoggerName, e.Time, e.Level, e.Message)
+	for _, f := range fields {
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		keys := make([]string, 0, len(enc.Fields))
+		for k := range enc.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			value := fmt.Sprintf("%+v", enc.Fields[k])
+			msg += fmt.Sprintf("\n%s=%s", tgbotapi.EscapeText(parseMode, k), tgbotapi.EscapeText(parseMode, value))
+		}
+	}
+	return msg
+}
+
+func TestEscapeText(t *testing.T) {
+	text := `_*[]()~` + "`" + `>#+-=|{}.!<&\ plain`
+	for _, parseMode := range []string{"", tgbotapi.ModeMarkdown, tgbotapi.ModeMarkdownV2, tgbotapi.ModeHTML} {
+		if got, want := escapeText(parseMode, text), tgbotapi.EscapeText(parseMode, text); got != want {
+			t.Errorf("escapeText(%q) = %q, want %q", parseMode, got, want)
+		}
+	}
+}
+
+func TestDefaultFormatEscapesHeader(t *testing.T) {
+	c := newTelegramClient("", nil)
+	e := zapcore.Entry{
+		Level:      zapcore.InfoLevel,
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC),
+		LoggerName: "api.v2-http",
+		Message:    "ok",
+	}
+	got := c.defaultFormat(e, entryOverrides{}, nil, tgbotapi.ModeMarkdownV2)
+	want := "Logger: api\\.v2\\-http\n2024\\-01\\-02 03:04:05\\.0000006 \\+0000 UTC\ninfo\nok"
+	if got != want {
+		t.Errorf("defaultFormat() = %q, want %q", got, want)
+	}
+}
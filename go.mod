@@ -1,9 +1,10 @@
 module github.com/alfonmga/zap2telegram
 
-go 1.18
+go 1.20
 
 require (
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	go.uber.org/goleak v1.2.1
 	go.uber.org/zap v1.23.0
 )
 
@@ -0,0 +1,50 @@
+package zap2telegram
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// SentRecord is a message sent (or failed to be sent) to a chat, see WithHistory
+type SentRecord struct {
+	Time   time.Time     // time the message was sent
+	ChatID int64         // chat the message was sent to
+	Level  zapcore.Level // level of the entry
+	Text   string        // message text (or document content / photo caption)
+	Err    error         // error sending the message, nil if it was sent
+}
+
+// history is a ring buffer of the last messages sent
+type history struct {
+	mu      sync.Mutex
+	records []SentRecord
+	next    int  // index of the next record to overwrite
+	full    bool // the buffer has wrapped around
+}
+
+func newHistory(n int) *history {
+	return &history{records: make([]SentRecord, n)}
+}
+
+// add records a message, overwriting the oldest one once the buffer is full
+func (h *history) add(r SentRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records[h.next] = r
+	h.next = (h.next + 1) % len(h.records)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// recent returns the records from the oldest to the newest one
+func (h *history) recent() []SentRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]SentRecord(nil), h.records[:h.next]...)
+	}
+	return append(append(make([]SentRecord, 0, len(h.records)), h.records[h.next:]...), h.records[:h.next]...)
+}
@@ -0,0 +1,79 @@
+package zap2telegram
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// IdempotencyStore persists the keys of the recently sent entries so identical entries aren't sent again
+// within a time window, even across process restarts when the store is persistent (E.g: a file or Redis)
+type IdempotencyStore interface {
+	// SetIfAbsent stores the key for the given ttl, reporting false if it was already stored and not expired
+	SetIfAbsent(key string, ttl time.Duration) (bool, error)
+	// Delete removes the key, so an entry that failed to be sent (or was dropped) can be sent again
+	Delete(key string) error
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore, it doesn't survive process restarts
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	expires map[string]time.Time // expiration time of each key
+}
+
+// NewMemoryIdempotencyStore returns a new in-memory IdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{expires: map[string]time.Time{}}
+}
+
+// SetIfAbsent stores the key for the given ttl, reporting false if it was already stored and not expired
+func (s *MemoryIdempotencyStore) SetIfAbsent(key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expires, ok := s.expires[key]; ok && now.Before(expires) {
+		return false, nil
+	}
+	for k, expires := range s.expires {
+		if !now.Before(expires) {
+			delete(s.expires, k) // forget the expired keys so the store doesn't grow forever
+		}
+	}
+	s.expires[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Delete removes the key, so an entry that failed to be sent (or was dropped) can be sent again
+func (s *MemoryIdempotencyStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.expires, key)
+	return nil
+}
+
+// idempotencyKey returns the key identifying the identical entries: same level, logger name, message and fields
+func idempotencyKey(e zapcore.Entry, fields []zapcore.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		if !isReservedField(f) {
+			f.AddTo(enc)
+		}
+	}
+	encodedFields, err := json.Marshal(enc.Fields) // sorted keys
+	if err != nil {
+		encodedFields = []byte(err.Error())
+	}
+	h := sha256.New()
+	h.Write([]byte(e.Level.String()))
+	h.Write([]byte{0})
+	h.Write([]byte(e.LoggerName))
+	h.Write([]byte{0})
+	h.Write([]byte(e.Message))
+	h.Write([]byte{0})
+	h.Write(encodedFields)
+	return hex.EncodeToString(h.Sum(nil))
+}
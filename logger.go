@@ -0,0 +1,24 @@
+package zap2telegram
+
+import (
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewLogger returns a ready to use logger sending its entries to Telegram (configured with the given options)
+// and writing them to stderr as well. The fatal entries are sent to Telegram before the process exits.
+func NewLogger(botAccessToken string, chatIDs []int64, opts ...Option) (*zap.Logger, error) {
+	telegramCore, err := NewTelegramCore(botAccessToken, chatIDs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	consoleCore := zapcore.NewCore(
+		zapcore.NewConsoleEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.Lock(os.Stderr),
+		zapcore.DebugLevel,
+	)
+	fatalHook := telegramCore.(*TelegramCore).FatalHook(nil)
+	return zap.New(zapcore.NewTee(telegramCore, consoleCore), zap.AddCaller(), zap.WithFatalHook(fatalHook)), nil
+}
@@ -0,0 +1,98 @@
+package zap2telegram
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// MetricsObserver is notified about the delivery of the messages (E.g: to bridge them to Prometheus).
+// Its methods are called synchronously from the logging path so they must be non-blocking.
+type MetricsObserver interface {
+	OnSent(level zapcore.Level)              // a message has been sent to a chat
+	OnFailed(level zapcore.Level, err error) // a message has failed to be sent
+	OnDropped(level zapcore.Level)           // an entry has been dropped without being sent
+	OnQueued(level zapcore.Level)            // an entry has been added to the queue
+}
+
+// DropReason is the reason why an entry has been dropped
+type DropReason string
+
+// Reasons why an entry is dropped
+const (
+	DropReasonQueueFull     DropReason = "queue_full"     // the queue or the async buffer is full (see WithQueue and WithAsyncBuffer)
+	DropReasonSampled       DropReason = "sampled"        // sampled out (see WithSampling)
+	DropReasonMaxInflight   DropReason = "max_inflight"   // too many async messages being sent (see WithMaxInflight)
+	DropReasonStale         DropReason = "stale"          // the entry context was done before flushing the queue (see ContextField)
+	DropReasonThrottled     DropReason = "throttled"      // over the global messages cap (see WithGlobalThrottle)
+	DropReasonRateLimited   DropReason = "rate_limited"   // sent too soon after the previous one of its level (see WithLevelRateLimit)
+	DropReasonDuplicate     DropReason = "duplicate"      // an identical entry was recently sent (see WithIdempotencyStore)
+	DropReasonChatThrottled DropReason = "chat_throttled" // sent too soon to a chat after the previous one of its level (see WithPerChatLevelThrottle)
+)
+
+// DropReasonObserver can be optionally implemented by a MetricsObserver to be notified about
+// the reason of each dropped entry, it's called along with OnDropped
+type DropReasonObserver interface {
+	OnDroppedReason(level zapcore.Level, reason DropReason)
+}
+
+// metricsObserver is a nil-safe MetricsObserver wrapper which also keeps the delivery counters
+type metricsObserver struct {
+	MetricsObserver
+	stats *coreStats // delivery counters (if any)
+}
+
+func (o metricsObserver) sent(l zapcore.Level) {
+	if o.stats != nil {
+		o.stats.sent.Add(1)
+	}
+	if o.MetricsObserver != nil {
+		o.OnSent(l)
+	}
+}
+
+func (o metricsObserver) failed(l zapcore.Level, err error) {
+	if o.stats != nil {
+		o.stats.failed.Add(1)
+	}
+	if o.MetricsObserver != nil {
+		o.OnFailed(l, err)
+	}
+}
+
+func (o metricsObserver) dropped(l zapcore.Level, reason DropReason) {
+	if o.stats != nil {
+		o.stats.dropped.Add(1)
+	}
+	if o.MetricsObserver != nil {
+		o.OnDropped(l)
+		if ro, ok := o.MetricsObserver.(DropReasonObserver); ok {
+			ro.OnDroppedReason(l, reason)
+		}
+	}
+}
+
+func (o metricsObserver) queued(l zapcore.Level) {
+	if o.MetricsObserver != nil {
+		o.OnQueued(l)
+	}
+}
+
+// Stats are the delivery counters of a core since it was created
+type Stats struct {
+	Sent    uint64 // messages sent to a chat
+	Failed  uint64 // messages failed to be sent to a chat
+	Dropped uint64 // entries dropped without being sent
+}
+
+// coreStats are the delivery counters of a core
+type coreStats struct {
+	sent    atomic.Uint64 // messages sent to a chat
+	failed  atomic.Uint64 // messages failed to be sent to a chat
+	dropped atomic.Uint64 // entries dropped without being sent
+}
+
+// snapshot returns the current counters
+func (s *coreStats) snapshot() Stats {
+	return Stats{Sent: s.sent.Load(), Failed: s.failed.Load(), Dropped: s.dropped.Load()}
+}
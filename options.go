@@ -2,34 +2,70 @@ package zap2telegram
 
 import (
 	"context"
+	"fmt"
 	"go.uber.org/zap"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.uber.org/zap/zapcore"
 )
 
 type Option func(*TelegramCore) error
 
-// WithLevelEnabler uses the provided enabler to decide which level should be logged
+// WithLevelEnabler uses the provided enabler to decide which level should be logged (E.g: warn and error but not
+// info nor the levels above), replacing the default one wholesale. WithLevel and WithStrongLevel are shortcuts for it,
+// so when several of these options are combined the last one wins. The levels muted at runtime (see MuteLevel)
+// are never sent no matter the enabler.
 func WithLevelEnabler(l zapcore.LevelEnabler) Option {
 	return func(h *TelegramCore) error {
+		if l == nil {
+			return ErrLevelEnabler
+		}
 		h.enabler = l
 		return nil
 	}
 }
 
-// WithLevel sends messages equal or above specified level
+// WithLevel sends messages equal or above specified level, see WithLevelEnabler
 func WithLevel(l zapcore.Level) Option {
+	return WithLevelEnabler(zap.NewAtomicLevelAt(l))
+}
+
+// WithStrongLevel sends only messages with specified level, see WithLevelEnabler
+func WithStrongLevel(l zapcore.Level) Option {
+	return WithLevelEnabler(zap.LevelEnablerFunc(func(lvl zapcore.Level) bool { return lvl == l }))
+}
+
+// WithEntryFilter only sends the entries for which f returns true (E.g: info entries with an alert=true field
+// and all the error ones). It's applied in Write, after the level check done in Check (which has no access
+// to the fields) and before the sampling, so filtered out entries don't count towards it nor as dropped.
+func WithEntryFilter(f func(e zapcore.Entry, fields []zapcore.Field) bool) Option {
 	return func(h *TelegramCore) error {
-		h.enabler = zap.NewAtomicLevelAt(l)
+		h.entryFilter = f
 		return nil
 	}
 }
 
-// WithStrongLevel sends only messages with specified level
-func WithStrongLevel(l zapcore.Level) Option {
+// WithSkipEmpty doesn't send the entries with neither message nor fields (E.g: logger.Error("")), which would only
+// produce a confusing blank alert. The default formatter always omits the message line of the entries without message.
+func WithSkipEmpty() Option {
 	return func(h *TelegramCore) error {
-		h.enabler = zap.LevelEnablerFunc(func(lvl zapcore.Level) bool { return lvl == l })
+		h.skipEmpty = true
+		return nil
+	}
+}
+
+// WithRequireField only sends the entries with the given field (E.g: an "alert" tag), with any of the given values
+// when provided (compared to the value rendered as a string, E.g: "true"). The rest of entries are skipped
+// by this core only. It can be used several times to require several fields.
+func WithRequireField(key string, values ...string) Option {
+	return func(h *TelegramCore) error {
+		h.requiredFields = append(h.requiredFields, requiredField{key: key, values: values})
 		return nil
 	}
 }
@@ -51,16 +87,68 @@ func WithNotificationOn(levels []zapcore.Level) Option {
 	}
 }
 
-// WithParseMode sets parse mode for Telegram messages
-// (E.g: "ModeMarkdown", "ModeMarkdownV2" or "ModeHTML")
+// WithNotifyAboveLevel enables Telegram message notification for entries equal or above the specified level
+// and disables it for the ones below. Levels enabled through WithNotificationOn still notify.
+func WithNotifyAboveLevel(l zapcore.Level) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.notifyAboveLevel = &l
+		return nil
+	}
+}
+
+// WithNotifyWhen enables Telegram message notification for the entries matching the given condition regardless
+// of the level based and global settings (E.g: entries with a page=true field). SilentField and WithChatNotifications
+// still take precedence over it.
+func WithNotifyWhen(f func(e zapcore.Entry, fields []zapcore.Field) bool) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.notifyWhen = f
+		return nil
+	}
+}
+
+// WithChatNotifications enables (true) or disables (false) the Telegram message notification on specific chats
+// (E.g: an on-call chat always notified and an archive one always silent), regardless of the entry level.
+// It takes precedence over WithNotificationOn, WithNotifyAboveLevel and WithDisabledNotification for the listed chats,
+// only SilentField takes precedence over it.
+func WithChatNotifications(chatNotifications map[int64]bool) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.chatNotifications = chatNotifications
+		return nil
+	}
+}
+
+// WithParseMode sets parse mode for Telegram messages, an empty parse mode clears it
+// (E.g: tgbotapi.ModeMarkdown, tgbotapi.ModeMarkdownV2 or tgbotapi.ModeHTML)
 // https://core.telegram.org/bots/api#formatting-options
 func WithParseMode(parseMode string) Option {
 	return func(h *TelegramCore) error {
+		if parseMode == "" {
+			h.telegramClient.parseMode = nil
+			return nil
+		}
+		if !isValidParseMode(parseMode) {
+			return ErrParseMode
+		}
 		h.telegramClient.parseMode = &parseMode
 		return nil
 	}
 }
 
+// WithChatParseModes sets the parse mode of the Telegram messages sent to specific chats,
+// falling back to the one set with WithParseMode for the rest of chats.
+// (E.g: "Markdown", "MarkdownV2" or "HTML", an empty parse mode sends plain text messages)
+func WithChatParseModes(parseModes map[int64]string) Option {
+	return func(h *TelegramCore) error {
+		for _, parseMode := range parseModes {
+			if parseMode != "" && !isValidParseMode(parseMode) {
+				return ErrParseMode
+			}
+		}
+		h.telegramClient.chatParseModes = parseModes
+		return nil
+	}
+}
+
 // WithFormatter sets a custom Telegram message formatter
 func WithFormatter(f func(e zapcore.Entry, fields []zapcore.Field) string) Option {
 	return func(h *TelegramCore) error {
@@ -69,6 +157,732 @@ func WithFormatter(f func(e zapcore.Entry, fields []zapcore.Field) string) Optio
 	}
 }
 
+// WithContextFormatter sets a formatter receiving the context of the entry, to enrich the messages with request scoped
+// data (E.g: the trace id). Zap cores don't receive any context so it's attached to the entry through ContextField,
+// usually on a request scoped logger (logger.With(zap2telegram.ContextField(r.Context()))), otherwise the formatter
+// receives the core context (see WithContext) or the background one. It takes precedence over WithFormatter.
+func WithContextFormatter(f func(ctx context.Context, e zapcore.Entry, fields []zapcore.Field) string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.contextFormatter = f
+		return nil
+	}
+}
+
+// WithFormatterPipeline transforms the formatted messages with the given stages (E.g: add a prefix, then redact secrets),
+// each stage receives the output of the previous one. The first stage receives the message formatted by the level,
+// custom or default formatter.
+func WithFormatterPipeline(stages ...func(in string, e zapcore.Entry, fields []zapcore.Field) string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.formatterPipeline = append(h.telegramClient.formatterPipeline, stages...)
+		return nil
+	}
+}
+
+// WithJSONFormat formats the messages as a single line JSON object with the level, time, msg, logger, caller
+// and fields keys, for the chats parsed by other bots. Use it without parse mode since the JSON isn't escaped.
+func WithJSONFormat() Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.formatter = jsonFormat
+		return nil
+	}
+}
+
+// WithTemplate sets a text/template based Telegram message formatter.
+// The template can access .Level, .Time, .Message, .LoggerName, .Caller, .Stack and the .Fields map.
+// (E.g: "[{{.Level}}] {{.Message}} {{.Fields.user_id}}")
+func WithTemplate(tmpl string) Option {
+	return func(h *TelegramCore) error {
+		f, err := newTemplateFormatter(tmpl)
+		if err != nil {
+			return err
+		}
+		h.telegramClient.formatter = f
+		return nil
+	}
+}
+
+// WithFooter appends the given footer to every message (E.g: "— checkout-svc @ host-7"),
+// whether or not a custom formatter is used. The footer is kept when a message is truncated.
+func WithFooter(footer string) Option {
+	return WithFooterFunc(func(zapcore.Entry) string { return footer })
+}
+
+// WithFooterFunc appends the footer returned by f to every message, see WithFooter
+func WithFooterFunc(f func(e zapcore.Entry) string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.footer = f
+		return nil
+	}
+}
+
+// WithDefaultLoggerName sets the logger name used by the default formatter in case of an unnamed Zap logger
+// (E.g: the service name)
+func WithDefaultLoggerName(name string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.defaultLoggerName = name
+		return nil
+	}
+}
+
+// WithVerboseErrors renders the error fields of the default formatter with their verbose chain
+// (E.g: the stacktrace of the github.com/pkg/errors errors) instead of just their message
+func WithVerboseErrors() Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.verboseErrors = true
+		return nil
+	}
+}
+
+// WithCodeBlock wraps the formatted messages in a code block of the given language (empty for none)
+// according to the parse mode, escaping the message accordingly. Messages are sent as is when there's no parse mode.
+// The code block is kept when a message is truncated (see WithMaxMessageLength).
+func WithCodeBlock(lang string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.codeBlock = &lang
+		return nil
+	}
+}
+
+// WithCompactFormat makes the default formatter render just the message followed by the fields on a single line,
+// dropping the logger name, time and level header
+func WithCompactFormat() Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.compactFormat = true
+		return nil
+	}
+}
+
+// WithFieldsAsCodeBlock makes the default formatter render the fields as a code block of aligned "key: value" lines
+// according to the parse mode (plain aligned lines without parse mode). It's ignored by the compact format.
+func WithFieldsAsCodeBlock() Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.fieldsAsCodeBlock = true
+		return nil
+	}
+}
+
+// WithThreadResolver sends each entry to the forum topic (message thread id) returned by the resolver
+// (E.g: the topic of the "service" field), the entry is sent without topic when ok is false.
+// The topic applies to all the chats the entry is sent to.
+func WithThreadResolver(resolver func(e zapcore.Entry, fields []zapcore.Field) (threadID int, ok bool)) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.threadResolver = resolver
+		return nil
+	}
+}
+
+// WithPackageThreadMap sends each entry to the forum topic of the package it was logged from (E.g: "payments/*"
+// to one topic and "shipping/*" to another), based on the entry caller (see zap.AddCaller). A package pattern matches
+// the package with the same import path or path suffix (E.g: "payments" matches "github.com/acme/shop/payments") and,
+// if it ends with "/*", its subpackages too. The longest matching pattern wins and the entries without match are sent
+// without topic. The topic set by WithThreadResolver (if any) takes precedence.
+func WithPackageThreadMap(threads map[string]int) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.packageThreads = threads
+		return nil
+	}
+}
+
+// WithThreadByField sends the entries sharing the same value of the given field (E.g: "request_id") to the same
+// forum topic, so the lifecycle of a request is grouped together. The topic of each value is created in each chat
+// (named "key: value") the first time it's logged and forgotten after an hour without entries. The entries without
+// the field, or whose topic can't be created, are sent as usual. It takes precedence over WithThreadResolver.
+func WithThreadByField(key string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.topics = newTopicCache(key)
+		return nil
+	}
+}
+
+// WithFieldsAsJSONAttachment sends the entry fields as a pretty-printed fields.json document replying to the message,
+// so the message is kept short while the whole structured context is still available. The formatters don't
+// receive the fields and the entries without fields are sent as usual. It's ignored by the status message.
+func WithFieldsAsJSONAttachment() Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.fieldsAsJSONAttachment = true
+		return nil
+	}
+}
+
+// WithHostname makes the default formatter render the host name (resolved once with os.Hostname),
+// so the alerts of a fleet can be told apart
+func WithHostname() Option {
+	return func(h *TelegramCore) error {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get the hostname: %w", err)
+		}
+		h.telegramClient.hostname = hostname
+		return nil
+	}
+}
+
+// WithHostnameValue makes the default formatter render the given host name (E.g: the pod name)
+func WithHostnameValue(hostname string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.hostname = hostname
+		return nil
+	}
+}
+
+// WithEscapeMessage sets whether the default formatter escapes the entry message according to the parse mode (enabled
+// by default), disable it to format the messages on purpose (E.g: logger.Info("<b>deploy</b> done") with HTML)
+func WithEscapeMessage(escape bool) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.escapeMessage = escape
+		return nil
+	}
+}
+
+// WithFieldSeparator sets the separator of the fields rendered by the default formatter (E.g: ", " or " | "),
+// they're separated by a new line by default (a space with the compact format)
+func WithFieldSeparator(separator string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.fieldSeparator = &separator
+		return nil
+	}
+}
+
+// WithEntrySeparator sets the separator of the queued entries merged into the same message (an empty line by default),
+// see WithQueueBatching
+func WithEntrySeparator(separator string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.entrySeparator = separator
+		return nil
+	}
+}
+
+// WithoutLoggerName makes the default formatter omit the "Logger: ..." line
+func WithoutLoggerName() Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.withoutLoggerName = true
+		return nil
+	}
+}
+
+// WithLevelText sets how the default formatter renders the levels (E.g: "🚨 ERROR" or "CRITICAL" for panic)
+func WithLevelText(f func(l zapcore.Level) string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.levelText = f
+		return nil
+	}
+}
+
+// WithMessageEffect sends the messages of the given levels (all of them if none) with a message effect animation
+// (E.g: a celebratory effect for "deploy succeeded"). Effects are only available in some chats (E.g: private ones),
+// the messages rejected due to the effect are sent once again without it.
+func WithMessageEffect(effectID string, levels ...zapcore.Level) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.messageEffectID = effectID
+		h.telegramClient.messageEffectLevels = nil
+		if len(levels) > 0 {
+			h.telegramClient.messageEffectLevels = make(map[zapcore.Level]bool, len(levels))
+			for _, l := range levels {
+				h.telegramClient.messageEffectLevels[l] = true
+			}
+		}
+		return nil
+	}
+}
+
+// WithSeverityDivider makes the default formatter render the divider line (E.g: "━━━━━━━━━━") above the messages
+// of the given level and above, so the high severity alerts stand out in a busy chat. The divider is escaped
+// according to the parse mode.
+func WithSeverityDivider(minLevel zapcore.Level, divider string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.severityDivider = divider
+		h.telegramClient.severityDividerLevel = minLevel
+		return nil
+	}
+}
+
+// WithSequenceNumbers numbers the entries accepted by Write to detect the messages lost or reordered while being
+// delivered. The number is rendered by the default formatter only (E.g: #1423), the custom formatters don't receive it.
+// The counter is shared with the cores created with With, starts at 1 every time the core is created and wraps around
+// to 0 after math.MaxUint64 entries (0 isn't rendered).
+func WithSequenceNumbers() Option {
+	return func(h *TelegramCore) error {
+		h.sequence = &atomic.Uint64{}
+		return nil
+	}
+}
+
+// WithTimeLayout sets the layout of the entry time and the time fields rendered by the default formatter
+// (E.g: time.RFC3339)
+func WithTimeLayout(layout string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.timeLayout = layout
+		return nil
+	}
+}
+
+// WithFieldValueFormatter sets a custom renderer of the field values used by the default formatter.
+// When f returns false the field is rendered as usual (E.g: to only override the rendering of specific field types).
+func WithFieldValueFormatter(f func(field zapcore.Field) (string, bool)) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.fieldValueFormatter = f
+		return nil
+	}
+}
+
+// WithNumberFormatting makes the default formatter render the integer field values grouping their digits
+// by thousands (E.g: 1,234,567)
+func WithNumberFormatting() Option {
+	return WithNumberFormatter(groupThousands)
+}
+
+// WithNumberFormatter makes the default formatter render the integer field values with the given formatter
+// (E.g: locale specific grouping)
+func WithNumberFormatter(f func(n int64) string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.numberFormatter = f
+		return nil
+	}
+}
+
+// WithEntitiesBuilder sets a Telegram message builder returning the plain message text along with its formatting
+// entities (https://core.telegram.org/bots/api#messageentity), so nothing needs to be escaped.
+// It takes precedence over any formatter and the parse mode is ignored.
+func WithEntitiesBuilder(f func(e zapcore.Entry, fields []zapcore.Field) (text string, entities []tgbotapi.MessageEntity)) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.entitiesBuilder = f
+		return nil
+	}
+}
+
+// WithMessageOverride replaces the entry message before formatting with the one returned by f when it returns true
+// (E.g: a user-friendly "Payment gateway degraded" alert instead of the technical message logged by the code)
+func WithMessageOverride(f func(e zapcore.Entry, fields []zapcore.Field) (string, bool)) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.messageOverride = f
+		return nil
+	}
+}
+
+// WithFieldOrder makes the default formatter render the fields with the given keys first, in the given order,
+// followed by the rest of fields sorted alphabetically (E.g: WithFieldOrder("trace_id", "error")).
+// Without it the fields are rendered in the order they were logged.
+func WithFieldOrder(keys ...string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.fieldOrder = make(map[string]int, len(keys))
+		for i, key := range keys {
+			if _, ok := h.telegramClient.fieldOrder[key]; !ok {
+				h.telegramClient.fieldOrder[key] = i
+			}
+		}
+		return nil
+	}
+}
+
+// WithLevelFormatter sets custom Telegram message formatters for specific levels.
+// Levels without a formatter fall back to the one set with WithFormatter or the default one.
+func WithLevelFormatter(formatters map[zapcore.Level]func(e zapcore.Entry, fields []zapcore.Field) string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.levelFormatters = formatters
+		return nil
+	}
+}
+
+// WithLargeMessageAsFile sends messages longer than threshold (in UTF-16 code units, as counted by Telegram) as a text document
+// with a short caption summarizing the entry, instead of a regular text message
+func WithLargeMessageAsFile(threshold int) Option {
+	return func(h *TelegramCore) error {
+		if threshold <= 0 {
+			return ErrLargeMessageThreshold
+		}
+		h.telegramClient.largeMessageThreshold = threshold
+		return nil
+	}
+}
+
+// WithAttachmentNamer sets the file name of the messages sent as a document (see WithLargeMessageAsFile),
+// which defaults to the entry level and time (E.g: error-2024-06-01-143000.txt)
+func WithAttachmentNamer(f func(e zapcore.Entry) string) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.attachmentNamer = f
+		return nil
+	}
+}
+
+// WithMaxMessageLength truncates messages longer than n UTF-16 code units (as counted by Telegram) appending a "… (truncated)" marker.
+// Messages longer than the Telegram limit (4096) are always truncated, even without this option or if n is greater,
+// unless they are sent as a document (see WithLargeMessageAsFile).
+func WithMaxMessageLength(n int) Option {
+	return func(h *TelegramCore) error {
+		if n <= 0 {
+			return ErrMaxMessageLength
+		}
+		h.telegramClient.maxMessageLength = n
+		return nil
+	}
+}
+
+// WithFallbackCore writes the entries that failed to be sent to Telegram to the given core (E.g: a stderr or file core).
+// It applies to all the sending modes (sync, async and queue). Only the entries enabled by the core are written.
+func WithFallbackCore(core zapcore.Core) Option {
+	return func(h *TelegramCore) error {
+		h.fallback = core
+		return nil
+	}
+}
+
+// WithConstructionFallback makes NewTelegramCore return a core writing the entries to the given core when
+// the bot API can't be created (E.g: no network at startup) instead of failing. The bot API creation is retried
+// in the background (until the core context is done, see WithContext) and the entries are sent to Telegram
+// once it succeeds, see TelegramCore.Ready. Only the entries enabled by the core are written.
+func WithConstructionFallback(core zapcore.Core) Option {
+	return func(h *TelegramCore) error {
+		h.constructionFallback = core
+		return nil
+	}
+}
+
+// WithCircuitBreaker stops sending messages after the given consecutive failures. Entries are written to the fallback core
+// (if any) while the circuit is open, then once the cooldown elapses a single message is sent to probe the recovery.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(h *TelegramCore) error {
+		if failures <= 0 || cooldown <= 0 {
+			return ErrCircuitBreaker
+		}
+		h.breaker = newCircuitBreaker(failures, cooldown)
+		return nil
+	}
+}
+
+// WithMetricsObserver sets an observer notified about the sent, failed, dropped and queued messages
+func WithMetricsObserver(o MetricsObserver) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.observer.MetricsObserver = o
+		return nil
+	}
+}
+
+// WithManualQueue queues the messages like WithQueue but they're only sent when the queue is flushed on demand
+// with Flush or Sync, there's no interval nor background consumer (E.g: to exercise the queue deterministically).
+// Entries logged while the queue is full are dropped.
+func WithManualQueue(queueSize int) Option {
+	return func(h *TelegramCore) error {
+		if queueSize <= 0 {
+			return ErrQueueSize
+		}
+		h.async = false
+		h.queue = true
+		h.manualQueue = true
+		h.entriesChan = make(chan chanEntry, queueSize)
+		return nil
+	}
+}
+
+// WithQueueJitter randomizes each queue interval by up to the given fraction (E.g: 0.2 for ±20%),
+// so multiple instances started at the same time don't flush their queues in sync. Only used along with WithQueue.
+func WithQueueJitter(fraction float64) Option {
+	return func(h *TelegramCore) error {
+		if fraction <= 0 || fraction >= 1 {
+			return ErrQueueJitter
+		}
+		h.queueJitter = fraction
+		return nil
+	}
+}
+
+// WithQueueCoalescing collapses the identical consecutive entries sent in the same queue flush (same level, logger name,
+// message and fields) into a single message suffixed with " ×N". Only used along with WithQueue.
+func WithQueueCoalescing() Option {
+	return func(h *TelegramCore) error {
+		h.queueCoalesce = true
+		return nil
+	}
+}
+
+// WithQueueBatching merges the queued entries of each chat into as few messages as possible within the message
+// length limit, so a flush of N short entries takes a few API calls instead of N (E.g: 50 short entries are usually
+// sent as 2 or 3 messages). Only the consecutive plain text entries sent to the same topic are merged, the rest
+// (entities, photos, replies, documents...) are sent on their own. A merged message notifies if any of its entries
+// would notify on its own (E.g: an info and an error entry merged together notify when errors do).
+// Only used along with WithQueue.
+func WithQueueBatching() Option {
+	return func(h *TelegramCore) error {
+		h.queueBatch = true
+		return nil
+	}
+}
+
+// WithSampling caps the messages sent for the same level and message: the first entries of each tick are sent,
+// then only one out of thereafter entries (none if zero). Sampled out entries are dropped.
+func WithSampling(tick time.Duration, first, thereafter int) Option {
+	return func(h *TelegramCore) error {
+		if tick <= 0 || first < 0 || thereafter < 0 {
+			return ErrSampling
+		}
+		h.sampler = newSampler(tick, first, thereafter)
+		return nil
+	}
+}
+
+// WithIdempotencyStore doesn't send the entries identical (same level, logger name, message and fields) to one sent
+// within the ttl, so a crash looping process doesn't flood the chats with the same startup error when the store
+// is persistent (E.g: a file or Redis backed store). See NewMemoryIdempotencyStore for an in-memory store.
+// The entries are sent anyway when the store fails. The key of an entry dropped or failed to be sent is deleted
+// from the store, so the next identical entry is sent.
+func WithIdempotencyStore(store IdempotencyStore, ttl time.Duration) Option {
+	return func(h *TelegramCore) error {
+		if store == nil || ttl <= 0 {
+			return ErrIdempotencyStore
+		}
+		h.idempotencyStore = store
+		h.idempotencyTTL = ttl
+		return nil
+	}
+}
+
+// WithLevelRateLimit enforces a minimum interval between the messages sent for the given levels
+// (E.g: at most one info message every 30 seconds), the entries arriving faster are dropped.
+// The levels not listed aren't limited.
+func WithLevelRateLimit(intervals map[zapcore.Level]time.Duration) Option {
+	return func(h *TelegramCore) error {
+		for _, interval := range intervals {
+			if interval <= 0 {
+				return ErrLevelRateLimit
+			}
+		}
+		h.levelRateLimiter = newLevelRateLimiter(intervals)
+		return nil
+	}
+}
+
+// WithPerChatLevelThrottle enforces a minimum interval between the messages of the given level sent to each chat
+// (E.g: at most one error alert per chat every 10 seconds). The messages arriving faster are suppressed and counted
+// in a "(+N suppressed)" note added to the next message sent to the chat. It can be used once per level.
+func WithPerChatLevelThrottle(level zapcore.Level, interval time.Duration) Option {
+	return func(h *TelegramCore) error {
+		if interval <= 0 {
+			return ErrPerChatLevelThrottle
+		}
+		if h.telegramClient.chatLevelThrottle == nil {
+			h.telegramClient.chatLevelThrottle = newChatLevelThrottle()
+		}
+		h.telegramClient.chatLevelThrottle.intervals[level] = interval
+		return nil
+	}
+}
+
+// WithGlobalThrottle caps the messages sent across all levels to max per sliding window (E.g: 60 per minute)
+// as a hard safety valve against alert storms. Entries over the cap are dropped and a single "(throttled)"
+// notice is sent per window.
+func WithGlobalThrottle(max int, window time.Duration) Option {
+	return func(h *TelegramCore) error {
+		if max <= 0 || window <= 0 {
+			return ErrGlobalThrottle
+		}
+		h.throttle = newThrottle(max, window)
+		return nil
+	}
+}
+
+// WithAPIEndpoint sets a custom Telegram bot API endpoint (E.g: a self-hosted Bot API server).
+// The endpoint is a template where the first %s is replaced by the bot access token and the second one
+// by the API method, like the default "https://api.telegram.org/bot%s/%s".
+func WithAPIEndpoint(endpoint string) Option {
+	return func(h *TelegramCore) error {
+		if strings.Count(endpoint, "%s") != 2 || strings.Count(endpoint, "%") != 2 {
+			return ErrAPIEndpoint
+		}
+		u, err := url.Parse(fmt.Sprintf(endpoint, "token", "method"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrAPIEndpoint
+		}
+		h.telegramClient.apiEndpoint = endpoint
+		return nil
+	}
+}
+
+// WithBotAPI uses the given bot API instance instead of creating a new one,
+// in which case the bot access token passed to NewTelegramCore is not required.
+// The core uses a copy of it, so WithContext and WithSendTimeout don't apply to the requests sent by the caller.
+func WithBotAPI(bot *tgbotapi.BotAPI) Option {
+	return func(h *TelegramCore) error {
+		if bot == nil {
+			return ErrBotAPI
+		}
+		h.telegramClient.injectedBotAPI = bot
+		return nil
+	}
+}
+
+// WithBackupBots sets backup bots to fail over to when the primary bot is unauthorized (E.g: revoked token)
+// or rate limited. The bot that last sent a message successfully keeps being used.
+func WithBackupBots(tokens ...string) Option {
+	return func(h *TelegramCore) error {
+		for _, token := range tokens {
+			if token == "" {
+				return ErrBotAccessToken
+			}
+		}
+		h.telegramClient.backupBotTokens = tokens
+		return nil
+	}
+}
+
+// WithShutdownMessage sets a message sent synchronously by Close once everything has been delivered
+// (E.g: "service stopping, 12 alerts sent"), built from the delivery stats of the run
+func WithShutdownMessage(f func(stats Stats) string) Option {
+	return func(h *TelegramCore) error {
+		h.shutdownMessage = f
+		return nil
+	}
+}
+
+// WithAllowEmptyChats allows creating the core without chat ids, nothing is sent until they're added at runtime
+// with TelegramCore.AddChatID (E.g: recipients registered from a webhook after startup). The entries without chats
+// are discarded.
+func WithAllowEmptyChats() Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.allowEmptyChats = true
+		return nil
+	}
+}
+
+// WithStartupMessage sends the given message to all chats once the core is created, as a smoke test of the integration.
+// The core creation fails if the message can't be sent.
+func WithStartupMessage(text string) Option {
+	return func(h *TelegramCore) error {
+		h.startupMessage = &text
+		return nil
+	}
+}
+
+// WithMaxInflight caps the async messages waiting to be sent or being sent to n.
+// Entries logged while the cap is reached are dropped. Only used along with the async mode.
+func WithMaxInflight(n int) Option {
+	return func(h *TelegramCore) error {
+		if n <= 0 {
+			return ErrMaxInflight
+		}
+		h.inflight = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// WithContext sets the root context of the requests sent to Telegram, cancelling it aborts all the outstanding
+// requests so shutdown can proceed promptly even when Telegram hangs (E.g: async messages still being sent)
+func WithContext(ctx context.Context) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.ctx = ctx
+		return nil
+	}
+}
+
+// WithSendTimeout sets the timeout of each request sent to Telegram
+func WithSendTimeout(timeout time.Duration) Option {
+	return func(h *TelegramCore) error {
+		if timeout <= 0 {
+			return ErrSendTimeout
+		}
+		h.telegramClient.sendTimeout = timeout
+		return nil
+	}
+}
+
+// WithStatusMessage edits the given message in place with every entry instead of sending new messages to the chats
+// (E.g: a pinned "system status" message). When messageID is zero the message is sent with the first entry.
+func WithStatusMessage(chatID int64, messageID int) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.statusMessage = &statusMessage{chatID: chatID, messageID: messageID}
+		return nil
+	}
+}
+
+// WithErrorHandler sets a handler notified about the delivery errors: the async and queued messages failing to be sent
+// (see TelegramCore.LastError), ErrUnauthorized once when the bot token is revoked and the messages downgraded
+// to plain text because Telegram couldn't parse them. They're written to the error output otherwise, see WithErrorOutput.
+// The handler must not log the errors back into a logger containing this core, failing deliveries would be retried
+// over and over again.
+func WithErrorHandler(f func(err error)) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.errorHandler = f
+		return nil
+	}
+}
+
+// WithErrorOutput sets the writer the delivery errors are written to when there's no error handler (stderr by default),
+// nil discards them. It's written to directly, never through a zap logger.
+func WithErrorOutput(w io.Writer) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.errorOutput = nil
+		if w != nil {
+			h.telegramClient.errorOutput = zapcore.Lock(zapcore.AddSync(w))
+		}
+		return nil
+	}
+}
+
+// WithDisabled never calls the Telegram bot API (E.g: in tests and local development), the entries are still
+// accepted, formatted and reported to the metrics observer as sent. The bot access token is optional.
+func WithDisabled() Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.disabled = true
+		return nil
+	}
+}
+
+// WithDryRun calls the given function with the chat id and the final text of each message instead of sending it
+// (E.g: to preview the alerts in staging or assert on them in tests). All the filtering and formatting still runs,
+// the bot API is never called and the bot access token is optional.
+func WithDryRun(f func(chatID int64, text string)) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.dryRun = f
+		return nil
+	}
+}
+
+// WithHistory keeps the last n messages sent to the chats (along with their time and error, if any) in memory,
+// see TelegramCore.RecentMessages (E.g: to check from a debug endpoint whether an alert was actually sent).
+func WithHistory(n int) Option {
+	return func(h *TelegramCore) error {
+		if n <= 0 {
+			return ErrHistorySize
+		}
+		h.telegramClient.history = newHistory(n)
+		return nil
+	}
+}
+
+// WithTap calls the given function with the chat id, the entry level and the final text of each message right before
+// sending it (E.g: to mirror the alerts to an audit log). Unlike WithDryRun the message is still sent.
+// It's called synchronously from the sending path so it must be non-blocking.
+func WithTap(f func(chatID int64, level zapcore.Level, text string)) Option {
+	return func(h *TelegramCore) error {
+		h.telegramClient.tap = f
+		return nil
+	}
+}
+
+// WithAsyncWorkers sets the number of dispatchers sending the async messages (1 by default). With a single
+// dispatcher the messages are sent in the order they were logged, with more of them the messages of different
+// entries are sent concurrently and may arrive out of order. The dispatchers run until the core is closed (see Close)
+// or the root context is done (see WithContext). Only used along with the async mode.
+func WithAsyncWorkers(n int) Option {
+	return func(h *TelegramCore) error {
+		if n <= 0 {
+			return ErrAsyncWorkers
+		}
+		h.asyncWorkers = n
+		return nil
+	}
+}
+
+// WithAsyncBuffer sets the number of async messages waiting to be sent by the dispatchers (1024 by default).
+// Entries logged while the buffer is full are dropped. Only used along with the async mode.
+func WithAsyncBuffer(size int) Option {
+	return func(h *TelegramCore) error {
+		if size <= 0 {
+			return ErrAsyncBuffer
+		}
+		h.asyncBuffer = size
+		return nil
+	}
+}
+
 // WithoutAsyncOpt disables default asynchronous mode and enables synchronous mode for messages sending (blocking)
 func WithoutAsyncOpt() Option {
 	return func(h *TelegramCore) error {
@@ -80,16 +894,23 @@ func WithoutAsyncOpt() Option {
 	}
 }
 
-// WithQueue sends the messages to Telegram in batches (burst) at the specified interval
+// WithQueue sends the messages to Telegram in batches (burst) at the specified interval.
+// Logging never blocks: the entries logged while the queue is full are dropped instead of blocking the caller until
+// there's room, see TelegramCore.Dropped and DropReasonQueueFull.
 func WithQueue(ctx context.Context, interval time.Duration, queueSize int) Option {
 	return func(h *TelegramCore) error {
+		if queueSize <= 0 {
+			return ErrQueueSize
+		}
+		if interval <= 0 {
+			return ErrQueueInterval
+		}
 		h.async = false
 		h.queue = true
+		h.manualQueue = false // the last queue option wins
 		h.intervalQueue = interval
 		h.entriesChan = make(chan chanEntry, queueSize)
-		go func() {
-			_ = h.consumeEntriesQueue(ctx)
-		}()
+		h.queueCtx = ctx
 		return nil
 	}
 }
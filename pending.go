@@ -0,0 +1,44 @@
+package zap2telegram
+
+import "sync"
+
+// pendingGroup counts the messages being sent in the background. Unlike a sync.WaitGroup, messages can be added
+// while waiting for them (E.g: an async entry logged during Flush), which then waits for the new ones too.
+type pendingGroup struct {
+	mu    sync.Mutex
+	count int
+	idle  *sync.Cond // signaled when no messages are being sent
+}
+
+func newPendingGroup() *pendingGroup {
+	p := &pendingGroup{}
+	p.idle = sync.NewCond(&p.mu)
+	return p
+}
+
+// Add adds delta, which may be negative, to the messages being sent
+func (p *pendingGroup) Add(delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count += delta
+	if p.count < 0 {
+		panic("zap2telegram: negative pending messages counter")
+	}
+	if p.count == 0 {
+		p.idle.Broadcast()
+	}
+}
+
+// Done marks a message as sent
+func (p *pendingGroup) Done() {
+	p.Add(-1)
+}
+
+// Wait waits until no messages are being sent
+func (p *pendingGroup) Wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.count > 0 {
+		p.idle.Wait()
+	}
+}
@@ -0,0 +1,138 @@
+package zap2telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+// extraParams returns the request parameters of the entry not supported by the bot API library (E.g: message_thread_id)
+func (c *telegramClient) extraParams(o entryOverrides) tgbotapi.Params {
+	if o.threadID == 0 {
+		return nil
+	}
+	return tgbotapi.Params{"message_thread_id": strconv.Itoa(o.threadID)}
+}
+
+// withMessageEffect returns the extra request parameters along with the message effect of the level (if any),
+// see WithMessageEffect
+func (c *telegramClient) withMessageEffect(extra tgbotapi.Params, l zapcore.Level) tgbotapi.Params {
+	if c.messageEffectID == "" || (c.messageEffectLevels != nil && !c.messageEffectLevels[l]) {
+		return extra
+	}
+	params := make(tgbotapi.Params, len(extra)+1)
+	for k, v := range extra {
+		params[k] = v
+	}
+	params["message_effect_id"] = c.messageEffectID
+	return params
+}
+
+// withoutMessageEffect returns the extra request parameters without the message effect (if any)
+func withoutMessageEffect(extra tgbotapi.Params) (tgbotapi.Params, bool) {
+	if _, ok := extra["message_effect_id"]; !ok {
+		return extra, false
+	}
+	params := make(tgbotapi.Params, len(extra))
+	for k, v := range extra {
+		if k != "message_effect_id" {
+			params[k] = v
+		}
+	}
+	return params, true
+}
+
+// sendRequest sends msg with the given bot along with the extra request parameters (if any).
+// The library configs can't be extended, so the request is built from scratch when there are extra parameters.
+func sendRequest(bot *tgbotapi.BotAPI, msg tgbotapi.Chattable, extra tgbotapi.Params) (tgbotapi.Message, error) {
+	if len(extra) == 0 {
+		return bot.Send(msg)
+	}
+	method, params, files, err := requestParams(msg)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	var resp *tgbotapi.APIResponse
+	if len(files) > 0 {
+		resp, err = bot.UploadFiles(method, params, files)
+	} else {
+		resp, err = bot.MakeRequest(method, params)
+	}
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	var sent tgbotapi.Message
+	err = json.Unmarshal(resp.Result, &sent)
+	return sent, err
+}
+
+// requestParams returns the request method, parameters and files of the messages sent by the core
+func requestParams(msg tgbotapi.Chattable) (string, tgbotapi.Params, []tgbotapi.RequestFile, error) {
+	switch m := msg.(type) {
+	case tgbotapi.MessageConfig:
+		params, err := baseChatParams(m.BaseChat)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		params.AddNonEmpty("text", m.Text)
+		params.AddBool("disable_web_page_preview", m.DisableWebPagePreview)
+		params.AddNonEmpty("parse_mode", m.ParseMode)
+		err = params.AddInterface("entities", m.Entities)
+		return "sendMessage", params, nil, err
+	case tgbotapi.DocumentConfig:
+		params, err := baseChatParams(m.BaseChat)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		params.AddNonEmpty("caption", m.Caption)
+		params.AddNonEmpty("parse_mode", m.ParseMode)
+		params.AddBool("disable_content_type_detection", m.DisableContentTypeDetection)
+		return "sendDocument", params, []tgbotapi.RequestFile{{Name: "document", Data: m.File}}, nil
+	case tgbotapi.PhotoConfig:
+		params, err := baseChatParams(m.BaseChat)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		params.AddNonEmpty("caption", m.Caption)
+		params.AddNonEmpty("parse_mode", m.ParseMode)
+		err = params.AddInterface("caption_entities", m.CaptionEntities)
+		return "sendPhoto", params, []tgbotapi.RequestFile{{Name: "photo", Data: m.File}}, err
+	}
+	return "", nil, nil, fmt.Errorf("unsupported message type %T", msg)
+}
+
+// messageText returns the chat id and the text of the messages sent by the core: the message text,
+// the content of the text documents or the photo caption
+func messageText(msg tgbotapi.Chattable) (int64, string) {
+	switch m := msg.(type) {
+	case tgbotapi.MessageConfig:
+		return m.ChatID, m.Text
+	case tgbotapi.DocumentConfig:
+		if file, ok := m.File.(tgbotapi.FileBytes); ok {
+			return m.ChatID, string(file.Bytes)
+		}
+		return m.ChatID, m.Caption
+	case tgbotapi.PhotoConfig:
+		return m.ChatID, m.Caption
+	case tgbotapi.EditMessageTextConfig:
+		return m.ChatID, m.Text
+	}
+	return 0, ""
+}
+
+// baseChatParams returns the request parameters common to all the messages
+func baseChatParams(chat tgbotapi.BaseChat) (tgbotapi.Params, error) {
+	params := make(tgbotapi.Params)
+	params.AddFirstValid("chat_id", chat.ChatID, chat.ChannelUsername)
+	params.AddNonZero("reply_to_message_id", chat.ReplyToMessageID)
+	params.AddBool("disable_notification", chat.DisableNotification)
+	params.AddBool("allow_sending_without_reply", chat.AllowSendingWithoutReply)
+	err := params.AddInterface("reply_markup", chat.ReplyMarkup)
+	return params, err
+}
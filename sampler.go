@@ -0,0 +1,50 @@
+package zap2telegram
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// samplerKey identifies the entries sampled together
+type samplerKey struct {
+	level   zapcore.Level
+	message string
+}
+
+// sampler caps the entries sent per level and message during each tick
+type sampler struct {
+	mu         sync.Mutex
+	tick       time.Duration // sampling interval
+	first      int           // entries always let through per tick
+	thereafter int           // then let through one out of this many entries (0 drops them all)
+	resetAt    time.Time     // end of the current tick
+	counts     map[samplerKey]int
+}
+
+func newSampler(tick time.Duration, first, thereafter int) *sampler {
+	return &sampler{tick: tick, first: first, thereafter: thereafter, counts: map[samplerKey]int{}}
+}
+
+// allow reports whether the entry should be sent or sampled out
+func (s *sampler) allow(e zapcore.Entry) bool {
+	now := e.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !now.Before(s.resetAt) {
+		// new tick, forget about the previous one
+		s.counts = map[samplerKey]int{}
+		s.resetAt = now.Add(s.tick)
+	}
+	k := samplerKey{e.Level, e.Message}
+	n := s.counts[k] + 1
+	s.counts[k] = n
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
@@ -0,0 +1,36 @@
+package zap2telegram
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSampling(t *testing.T) {
+	f := newFakeTelegram(t)
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithSampling(time.Minute, 2, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := core.(*TelegramCore)
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	write := func(at time.Time, msg string) {
+		t.Helper()
+		if err := tc.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Time: at, Message: msg}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		write(start, "repeated") // the 1st, 2nd, 5th and 8th ones are sent
+	}
+	write(start, "other")                     // sampled on its own
+	write(start.Add(time.Minute), "repeated") // new tick
+	if got := len(f.sent("sendMessage")); got != 6 {
+		t.Errorf("sent %d messages, want 6", got)
+	}
+	if got := tc.Dropped(); got != 4 {
+		t.Errorf("Dropped() = %d, want 4", got)
+	}
+}
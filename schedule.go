@@ -0,0 +1,95 @@
+package zap2telegram
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ScheduleAt sends the log entry at the given time instead of right away (E.g: a planned maintenance announcement).
+// The entry is kept in memory until then, so it's lost if the process exits before. Close sends the entries still
+// scheduled right away. Entries scheduled in the past are sent right away.
+func ScheduleAt(t time.Time) zap.Field {
+	return zap.Field{Key: scheduleKey, Type: zapcore.SkipType, Integer: t.UnixNano()}
+}
+
+// scheduleTime returns the time set through ScheduleAt (if any)
+func scheduleTime(fields []zapcore.Field) (time.Time, bool) {
+	for _, f := range fields {
+		if f.Key == scheduleKey && f.Type == zapcore.SkipType {
+			return time.Unix(0, f.Integer), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// scheduler keeps the timers of the scheduled entries until they're sent
+type scheduler struct {
+	mu      sync.Mutex
+	timers  map[*time.Timer]func() // send function of each scheduled entry
+	sending int                    // scheduled entries being sent
+	idle    *sync.Cond             // signaled when no scheduled entries are being sent
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{timers: map[*time.Timer]func(){}}
+	s.idle = sync.NewCond(&s.mu)
+	return s
+}
+
+// schedule calls send at the given time (or when fired by fireAll)
+func (s *scheduler) schedule(at time.Time, send func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var t *time.Timer
+	t = time.AfterFunc(time.Until(at), func() {
+		s.mu.Lock()
+		send, ok := s.timers[t]
+		if ok { // otherwise already fired by fireAll
+			delete(s.timers, t)
+			s.sending++
+		}
+		s.mu.Unlock()
+		if ok {
+			s.run(send)
+		}
+	})
+	s.timers[t] = send
+}
+
+// fireAll calls right away (in the background) the send function of the entries still scheduled,
+// they're being sent by the time it returns (see wait)
+func (s *scheduler) fireAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, send := range s.timers {
+		t.Stop()
+		s.sending++
+		go s.run(send)
+	}
+	s.timers = map[*time.Timer]func(){}
+}
+
+// run calls the send function of a scheduled entry whose time has come
+func (s *scheduler) run(send func()) {
+	defer func() {
+		s.mu.Lock()
+		s.sending--
+		if s.sending == 0 {
+			s.idle.Broadcast()
+		}
+		s.mu.Unlock()
+	}()
+	send()
+}
+
+// wait waits for the scheduled entries being sent, the ones still waiting for their time aren't waited for
+func (s *scheduler) wait() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.sending > 0 {
+		s.idle.Wait()
+	}
+}
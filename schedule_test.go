@@ -0,0 +1,53 @@
+package zap2telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestScheduleAt(t *testing.T) {
+	f := newFakeTelegram(t)
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithFormatter(func(e zapcore.Entry, _ []zapcore.Field) string { return e.Message }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := core.(*TelegramCore)
+	write := func(msg string, at time.Time) {
+		t.Helper()
+		if err := tc.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: msg}, []zapcore.Field{ScheduleAt(at)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	texts := func() []string {
+		var texts []string
+		for _, params := range f.sent("sendMessage") {
+			texts = append(texts, params["text"])
+		}
+		return texts
+	}
+	start := time.Now()
+	write("past", start.Add(-time.Minute))
+	write("soon", start.Add(50*time.Millisecond))
+	write("later", start.Add(time.Hour))
+	if got := texts(); len(got) != 1 || got[0] != "past" {
+		t.Fatalf("sent %q, want only the entry scheduled in the past", got)
+	}
+	for len(texts()) < 2 && time.Since(start) < time.Second {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := texts(); len(got) != 2 || got[1] != "soon" {
+		t.Fatalf("sent %q, want the entry scheduled soon sent too", got)
+	} else if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("the entry scheduled soon was sent after %s, want at least 50ms", elapsed)
+	}
+	if err := tc.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(); len(got) != 3 || got[2] != "later" {
+		t.Errorf("sent %q, want the entry scheduled later sent by Close", got)
+	}
+}
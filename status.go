@@ -0,0 +1,65 @@
+package zap2telegram
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+// statusMessage is a single message edited in place with every entry (E.g: a pinned "system status" message)
+type statusMessage struct {
+	mu        sync.Mutex
+	chatID    int64
+	messageID int // posted on the first entry when zero
+}
+
+// updateStatusMessage edits the status message with the message of the entry,
+// posting it first if it doesn't exist yet
+func (c *telegramClient) updateStatusMessage(e zapcore.Entry, o entryOverrides, render func(parseMode string) message) error {
+	s := c.statusMessage
+	parseMode := c.chatParseMode(s.chatID)
+	m := render(parseMode)
+	text := c.truncateMessage(m, parseMode)
+	var entities []tgbotapi.MessageEntity
+	if m.entities != nil {
+		parseMode = "" // ignored when using entities
+		entities = clipEntities(m.entities, text)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.messageID == 0 {
+		msg := tgbotapi.NewMessage(s.chatID, text)
+		msg.ParseMode = parseMode
+		msg.Entities = entities
+		msg.DisableNotification = c.isNotificationDisabled(s.chatID, e, o)
+		var sent tgbotapi.Message
+		if sent, err = c.send(e.Level, msg); err == nil {
+			s.messageID = sent.MessageID
+		}
+	} else {
+		edit := tgbotapi.NewEditMessageText(s.chatID, s.messageID, text)
+		edit.ParseMode = parseMode
+		edit.Entities = entities
+		if _, err = c.send(e.Level, edit); isMessageNotModifiedError(err) {
+			err = nil // same text as the current one
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to update status message in chat %d: %w", s.chatID, err)
+		c.observer.failed(e.Level, err)
+		return err
+	}
+	c.observer.sent(e.Level)
+	return nil
+}
+
+// isMessageNotModifiedError reports whether err is returned because an edited message has not changed
+func isMessageNotModifiedError(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && strings.Contains(tgErr.Message, "message is not modified")
+}
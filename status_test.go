@@ -0,0 +1,71 @@
+package zap2telegram
+
+import (
+	"strconv"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestStatusMessage(t *testing.T) {
+	f := newFakeTelegram(t)
+	f.fail = func(r fakeRequest) *tgbotapi.Error {
+		if r.method == "editMessageText" && r.params["text"] == "unchanged" {
+			return badRequest("Bad Request: message is not modified")
+		}
+		return nil
+	}
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithStatusMessage(-100, 0), WithFormatter(func(e zapcore.Entry, _ []zapcore.Field) string { return e.Message }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"starting", "unchanged", "healthy"} {
+		if err := core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: msg}, nil); err != nil {
+			t.Fatalf("Write(%q) = %v", msg, err)
+		}
+	}
+	sent := f.sent("sendMessage")
+	if len(sent) != 1 || sent[0]["chat_id"] != "-100" || sent[0]["text"] != "starting" {
+		t.Fatalf("sent %v, want the status message posted once with the first entry", sent)
+	}
+	f.mu.Lock()
+	var postedID string
+	for i, r := range f.requests {
+		if r.method == "sendMessage" {
+			postedID = strconv.Itoa(i + 1) // the fake message ids are the request numbers
+		}
+	}
+	f.mu.Unlock()
+	edits := f.sent("editMessageText")
+	if len(edits) != 2 {
+		t.Fatalf("edited the status message %d times, want 2", len(edits))
+	}
+	for _, edit := range edits {
+		if edit["chat_id"] != "-100" || edit["message_id"] != postedID {
+			t.Errorf("edited message %s in chat %s, want message %s in chat -100", edit["message_id"], edit["chat_id"], postedID)
+		}
+	}
+	if edits[1]["text"] != "healthy" {
+		t.Errorf("status message text = %q, want healthy", edits[1]["text"])
+	}
+}
+
+func TestExistingStatusMessage(t *testing.T) {
+	f := newFakeTelegram(t)
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithStatusMessage(-100, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "healthy"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if sent := f.sent("sendMessage"); len(sent) != 0 {
+		t.Errorf("sent %v, want the existing status message edited", sent)
+	}
+	if edits := f.sent("editMessageText"); len(edits) != 1 || edits[0]["message_id"] != "42" {
+		t.Errorf("edits = %v, want message 42 edited", edits)
+	}
+}
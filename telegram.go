@@ -1,8 +1,16 @@
 package zap2telegram
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.uber.org/zap/zapcore"
@@ -12,68 +20,883 @@ import (
 var (
 	defaultLoggerName          = "zap2telegram" // default logger name used by the default formatter in case of an unnamed Zap logger
 	defaultDisableNotification = false          // enable Telegram message notification by default
+	defaultEscapeMessage       = true           // escape the entry message according to the parse mode by default
+	maxConcurrentSends         = 4              // max number of messages sent concurrently
 )
 
+// Telegram limits
+const (
+	maxMessageLength = 4096 // max message text length (in UTF-16 code units, as counted by Telegram)
+	maxCaptionLength = 1024 // max document caption length (in UTF-16 code units)
+)
+
+// truncatedMarker is appended to the messages truncated due to WithMaxMessageLength
+const truncatedMarker = "… (truncated)"
+
+// message is a formatted message ready to be sent
+type message struct {
+	body       string                   // formatted entry
+	blockOpen  string                   // code block opening wrapping the body, it's never truncated
+	blockClose string                   // code block closing wrapping the body, it's never truncated
+	footer     string                   // footer appended after the body, it's never truncated
+	entities   []tgbotapi.MessageEntity // formatting entities of the body, the parse mode is ignored when set
+	attachment []byte                   // JSON fields sent as a document replying to the message (if any)
+}
+
+// text returns the full message text
+func (m message) text() string {
+	text := m.blockOpen + m.body + m.blockClose
+	if m.footer == "" {
+		return text
+	}
+	return text + "\n" + m.footer
+}
+
 // telegramCLient is a Telegram client
 type telegramClient struct {
+	botAccessToken             string // Telegram bot access token
+	apiEndpoint                string // Telegram bot API endpoint
 	botAPI                     *tgbotapi.BotAPI
-	chatIDs                    []int64                                              // chat ids to send messages to
-	disableNotification        bool                                                 // disable Telegram message notification
-	enableNotificationOnLevels []zapcore.Level                                      // enable Telegram message notification on specified levels
-	parseMode                  *string                                              // parse mode for Telegram message
-	formatter                  func(e zapcore.Entry, fields []zapcore.Field) string // Telegram messages format
+	injectedBotAPI             *tgbotapi.BotAPI                                                                 // bot API instance provided with WithBotAPI, the bot API is a copy of it
+	backupBotTokens            []string                                                                         // access tokens of the bots to fail over to
+	backupBotAPIs              []*tgbotapi.BotAPI                                                               // bots to fail over to when the primary one is unauthorized or rate limited
+	activeBot                  atomic.Int32                                                                     // index of the healthy bot in use (0 is the primary one)
+	ctx                        context.Context                                                                  // root context of the requests, cancelling it aborts the outstanding ones
+	sendTimeout                time.Duration                                                                    // timeout of each request
+	disabled                   bool                                                                             // never call the bot API, the messages are formatted and reported as sent
+	dryRun                     func(chatID int64, text string)                                                  // called instead of sending the messages, the bot API is never called
+	tap                        func(chatID int64, l zapcore.Level, text string)                                 // called right before sending each message
+	messageEffectID            string                                                                           // effect of the messages (premium chats only)
+	messageEffectLevels        map[zapcore.Level]bool                                                           // levels of the messages sent with the effect, all of them when nil
+	history                    *history                                                                         // last messages sent
+	chatLevelThrottle          *chatLevelThrottle                                                               // min interval between the messages of each level sent to each chat
+	chatIDs                    []int64                                                                          // chat ids to send messages to
+	chatIDsMu                  sync.RWMutex                                                                     // guards the chat ids added or removed at runtime
+	allowEmptyChats            bool                                                                             // no chat ids are needed, they can be added at runtime
+	disableNotification        bool                                                                             // disable Telegram message notification
+	enableNotificationOnLevels []zapcore.Level                                                                  // enable Telegram message notification on specified levels
+	notifyAboveLevel           *zapcore.Level                                                                   // enable Telegram message notification on this level and above only
+	chatNotifications          map[int64]bool                                                                   // enable (or disable) Telegram message notification on specific chats
+	notifyWhen                 func(e zapcore.Entry, fields []zapcore.Field) bool                               // enable Telegram message notification on the entries matching it
+	parseMode                  *string                                                                          // parse mode for Telegram message
+	chatParseModes             map[int64]string                                                                 // parse mode for the Telegram messages of specific chats
+	formatter                  func(e zapcore.Entry, fields []zapcore.Field) string                             // Telegram messages format
+	contextFormatter           func(ctx context.Context, e zapcore.Entry, fields []zapcore.Field) string        // Telegram messages format using the entry context
+	levelFormatters            map[zapcore.Level]func(e zapcore.Entry, fields []zapcore.Field) string           // Telegram messages format for specific levels
+	formatterPipeline          []func(in string, e zapcore.Entry, fields []zapcore.Field) string                // stages transforming the formatted message
+	entitiesBuilder            func(e zapcore.Entry, fields []zapcore.Field) (string, []tgbotapi.MessageEntity) // Telegram messages text and entities
+	messageOverride            func(e zapcore.Entry, fields []zapcore.Field) (string, bool)                     // replace the entry message before formatting
+	threadResolver             func(e zapcore.Entry, fields []zapcore.Field) (int, bool)                        // forum topic of each entry
+	packageThreads             map[string]int                                                                   // forum topic of the entries logged from each package
+	topics                     *topicCache                                                                      // forum topics created for the values of a field
+	largeMessageThreshold      int                                                                              // send messages longer than this (in UTF-16 code units) as a document
+	attachmentNamer            func(e zapcore.Entry) string                                                     // file name of the messages sent as a document
+	maxMessageLength           int                                                                              // truncate messages longer than this (in UTF-16 code units)
+	footer                     func(e zapcore.Entry) string                                                     // message footer appended after the formatted message
+	defaultLoggerName          string                                                                           // logger name used by the default formatter in case of an unnamed Zap logger
+	verboseErrors              bool                                                                             // render the verbose error chain of the error fields
+	timeLayout                 string                                                                           // layout of the times rendered by the default formatter (time.Time.String when empty)
+	levelText                  func(l zapcore.Level) string                                                     // default formatter renders the levels with this function (if any)
+	fieldValueFormatter        func(f zapcore.Field) (string, bool)                                             // custom renderer of the field values
+	numberFormatter            func(n int64) string                                                             // default formatter renders the integer field values with this formatter (if any)
+	fieldOrder                 map[string]int                                                                   // default formatter renders these fields first (in this order) and the rest alphabetically
+	compactFormat              bool                                                                             // default formatter only renders the message and the fields on a single line
+	withoutLoggerName          bool                                                                             // default formatter omits the logger name line
+	escapeMessage              bool                                                                             // default formatter escapes the entry message according to the parse mode
+	severityDivider            string                                                                           // default formatter renders this line above the entries of the divider level and above
+	severityDividerLevel       zapcore.Level                                                                    // min level of the entries rendered with the severity divider
+	hostname                   string                                                                           // default formatter renders this host name (if any)
+	codeBlock                  *string                                                                          // wrap the messages in a code block of this language
+	fieldsAsCodeBlock          bool                                                                             // default formatter renders the fields as an aligned key: value code block
+	fieldSeparator             *string                                                                          // default formatter separates the fields with this separator (if any)
+	entrySeparator             string                                                                           // separator of the queued entries merged into the same message
+	fieldsAsJSONAttachment     bool                                                                             // send the fields as a JSON document instead of rendering them in the message
+	observer                   metricsObserver                                                                  // notified about the messages delivery
+	errorHandler               func(error)                                                                      // notified about the delivery errors
+	errorOutput                io.Writer                                                                        // the delivery errors are written here when there's no error handler
+	lastMessageIDsMu           sync.Mutex
+	statusMessage              *statusMessage // edit this message in place instead of sending new messages
+	chatWorkersMu              sync.Mutex
+	chatWorkers                map[int64]*chatWorker // send the messages of each chat in order
+	sendSlots                  chan struct{}         // semaphore capping the messages being sent at the same time
+	lastMessageIDs             map[int64]int         // id of the last message sent to each chat
 }
 
-// newTelegramClient returns a new Telegram client with the specified options
-func newTelegramClient(botAccesstoken string, chatIDs []int64) (*telegramClient, error) {
-	bot, err := tgbotapi.NewBotAPI(botAccesstoken)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create a new Telegram bot API instance: %w", err)
-	}
+// newTelegramClient returns a new Telegram client with the specified options.
+// The bot API instance is created later with initBotAPI, once all the options have been applied.
+func newTelegramClient(botAccesstoken string, chatIDs []int64) *telegramClient {
 	return &telegramClient{
-		botAPI:              bot,
+		botAccessToken:      botAccesstoken,
+		apiEndpoint:         tgbotapi.APIEndpoint,
 		chatIDs:             chatIDs,
 		disableNotification: defaultDisableNotification,
-	}, nil
+		defaultLoggerName:   defaultLoggerName,
+		escapeMessage:       defaultEscapeMessage,
+		entrySeparator:      defaultEntrySeparator,
+		errorOutput:         zapcore.Lock(os.Stderr),
+		sendSlots:           make(chan struct{}, maxConcurrentSends),
+	}
 }
 
-// Logger: zap2telegram
-// 11:25:59 01.01.2007
-// info
-// Hello bar
-func (c *telegramClient) formatMessage(e zapcore.Entry, fields []zapcore.Field) string {
-	if c.formatter != nil {
-		return c.formatter(e, fields)
+// initBotAPI creates the Telegram bot API instances (the primary one, unless provided with WithBotAPI, and the backup ones).
+// Nothing is changed when it fails, so it can be retried.
+func (c *telegramClient) initBotAPI() error {
+	if c.isOffline() {
+		return nil
+	}
+	backups := make([]*tgbotapi.BotAPI, 0, len(c.backupBotTokens))
+	for i, token := range c.backupBotTokens {
+		backup, err := tgbotapi.NewBotAPIWithClient(token, c.apiEndpoint, c.httpClient(&http.Client{}))
+		if err != nil {
+			return fmt.Errorf("failed to create a new Telegram bot API instance for backup bot #%d: %w", i+1, err)
+		}
+		backups = append(backups, backup)
+	}
+	if c.injectedBotAPI != nil {
+		bot := *c.injectedBotAPI // the caller's bot (and its HTTP client) is left untouched
+		bot.Client = c.httpClient(bot.Client)
+		c.botAPI = &bot
+	} else {
+		bot, err := tgbotapi.NewBotAPIWithClient(c.botAccessToken, c.apiEndpoint, c.httpClient(&http.Client{}))
+		if err != nil {
+			return fmt.Errorf("failed to create a new Telegram bot API instance: %w", err)
+		}
+		c.botAPI = bot
+	}
+	c.backupBotAPIs = backups
+	return nil
+}
+
+// httpClient returns the given client bound to the root context and send timeout (if any)
+func (c *telegramClient) httpClient(client tgbotapi.HTTPClient) tgbotapi.HTTPClient {
+	if c.ctx == nil && c.sendTimeout == 0 {
+		return client
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &contextHTTPClient{ctx: ctx, timeout: c.sendTimeout, client: client}
+}
+
+// contextHTTPClient sends each request with a context derived from a root context, so all the outstanding
+// requests are aborted once the root context is done
+type contextHTTPClient struct {
+	ctx     context.Context
+	timeout time.Duration // timeout of each request (none if zero)
+	client  tgbotapi.HTTPClient
+}
+
+func (c *contextHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(c.ctx, c.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.ctx)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
 	}
-	loggerName := defaultLoggerName
-	if e.LoggerName != "" {
-		loggerName = e.LoggerName
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel} // the body is read after Do returns
+	return resp, nil
+}
+
+// cancelOnCloseBody cancels the request context once the response body is closed
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// isOffline reports whether the bot API is never called (see WithDisabled and WithDryRun)
+func (c *telegramClient) isOffline() bool {
+	return c.disabled || c.dryRun != nil
+}
+
+// bot returns the i-th bot, the primary one being the first one followed by the backup ones
+func (c *telegramClient) bot(i int) *tgbotapi.BotAPI {
+	if i == 0 {
+		return c.botAPI
 	}
-	return fmt.Sprintf("Logger: %s\n%s\n%s\n%s", loggerName, e.Time, e.Level, e.Message)
+	return c.backupBotAPIs[i-1]
 }
 
-// sendMessage sends a message all specified chat ids
-func (c *telegramClient) sendMessage(e zapcore.Entry, fields []zapcore.Field) error {
-	for _, chatID := range c.chatIDs {
-		msg := tgbotapi.NewMessage(chatID, c.formatMessage(e, fields))
-		msg.DisableNotification = c.disableNotification
-		if len(c.enableNotificationOnLevels) > 0 {
-			for _, level := range c.enableNotificationOnLevels {
-				if e.Level == level {
-					msg.DisableNotification = false // enable notification for this message
-					break
+// send sends msg (of an entry of the given level) with the healthy bot in use. When the bot is unauthorized
+// or rate limited it fails over to the next bot, which is used from now on if the message is sent.
+// Nothing is sent when the client is disabled or in dry run mode.
+func (c *telegramClient) send(l zapcore.Level, msg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return c.sendWithParams(l, msg, nil)
+}
+
+// sendWithParams is like send but adds the extra request parameters (if any) to the request, see extraParams
+func (c *telegramClient) sendWithParams(l zapcore.Level, msg tgbotapi.Chattable, extra tgbotapi.Params) (tgbotapi.Message, error) {
+	if c.tap != nil {
+		chatID, text := messageText(msg)
+		c.tap(chatID, l, text)
+	}
+	var sent tgbotapi.Message
+	var err error
+	switch {
+	case c.dryRun != nil:
+		c.dryRun(messageText(msg))
+	case c.disabled:
+	default:
+		sent, err = c.sendWithFailover(msg, extra)
+		if err != nil && isMessageEffectError(err) {
+			if withoutEffect, ok := withoutMessageEffect(extra); ok {
+				// the effect is only available in some chats (E.g: private ones), the message is sent anyway
+				effectErr := err
+				if sent, err = c.sendWithFailover(msg, withoutEffect); err == nil {
+					c.handleError(fmt.Errorf("message sent without effect, failed to send it with effect: %w", effectErr))
 				}
 			}
 		}
-		if c.parseMode != nil {
-			msg.ParseMode = *c.parseMode
+	}
+	if c.history != nil {
+		chatID, text := messageText(msg)
+		c.history.add(SentRecord{Time: time.Now(), ChatID: chatID, Level: l, Text: text, Err: err})
+	}
+	return sent, err
+}
+
+// sendWithFailover sends msg with the healthy bot in use, failing over to the next bot when it's unauthorized
+// or rate limited
+func (c *telegramClient) sendWithFailover(msg tgbotapi.Chattable, extra tgbotapi.Params) (tgbotapi.Message, error) {
+	bots := 1 + len(c.backupBotAPIs)
+	active := int(c.activeBot.Load())
+	var err error
+	for i := 0; i < bots; i++ {
+		current := (active + i) % bots
+		var sent tgbotapi.Message
+		sent, err = sendRequest(c.bot(current), msg, extra)
+		if err != nil && isFailoverError(err) {
+			continue
 		}
-		_, err := c.botAPI.Send(msg)
-		if err != nil {
-			err := fmt.Errorf("failed to send message to chat %d: %w", chatID, err)
-			log.Println(err) // FIXME: how to log this error without using the default logger and avoid infinite recursion?
-			return err
+		if err == nil && current != active {
+			c.activeBot.CompareAndSwap(int32(active), int32(current))
+		}
+		return sent, err
+	}
+	return tgbotapi.Message{}, err
+}
+
+// isUnauthorizedError reports whether err means the bot is unauthorized (E.g: revoked token)
+func isUnauthorizedError(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusUnauthorized
+}
+
+// isMessageEffectError reports whether err means the message effect was rejected (E.g: EFFECT_ID_INVALID)
+func isMessageEffectError(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusBadRequest && strings.Contains(strings.ToUpper(tgErr.Message), "EFFECT")
+}
+
+// isParseEntitiesError reports whether err means Telegram couldn't parse the message according to its parse mode
+func isParseEntitiesError(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusBadRequest && strings.Contains(tgErr.Message, "can't parse entities")
+}
+
+// isMessageTooLongError reports whether err means the message text exceeds the Telegram message length limit
+func isMessageTooLongError(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusBadRequest && strings.Contains(tgErr.Message, "message is too long")
+}
+
+// isFailoverError reports whether err means the bot can't be used anymore (E.g: revoked token or rate limited).
+// A forbidden error only means the bot was blocked or removed from that chat, it can still send to the rest.
+func isFailoverError(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		return false
+	}
+	switch tgErr.Code {
+	case http.StatusUnauthorized, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// renderMessage formats the entry, wraps it in a code block and adds the footer (if any)
+func (c *telegramClient) renderMessage(e zapcore.Entry, o entryOverrides, fields []zapcore.Field, parseMode string) message {
+	var m message
+	if c.fieldsAsJSONAttachment && len(fields) > 0 {
+		if b, err := fieldsJSON(fields); err == nil {
+			m.attachment = b
+			fields = nil // keep the message short, the fields are only sent in the attachment
+		}
+	}
+	if c.entitiesBuilder != nil {
+		m.body, m.entities = c.entitiesBuilder(e, fields)
+		if m.entities == nil {
+			m.entities = []tgbotapi.MessageEntity{} // the parse mode is still ignored
+		}
+	} else {
+		m.body = c.formatMessage(e, o, fields, parseMode)
+	}
+	if c.codeBlock != nil && m.entities == nil {
+		m.body, m.blockOpen, m.blockClose = c.wrapCodeBlock(m.body, parseMode)
+	}
+	if c.footer != nil {
+		m.footer = c.footer(e)
+	}
+	return m
+}
+
+// formatMessage formats the entry with the formatter set for its level, the custom formatter or the default one,
+// then runs the formatter pipeline stages (if any). Only the default formatter takes into account the parse mode.
+func (c *telegramClient) formatMessage(e zapcore.Entry, o entryOverrides, fields []zapcore.Field, parseMode string) string {
+	text := c.baseFormat(e, o, fields, parseMode)
+	for _, stage := range c.formatterPipeline {
+		text = stage(text, e, fields)
+	}
+	return text
+}
+
+// baseFormat formats the entry with the formatter set for its level, the context formatter, the custom formatter
+// or the default one
+func (c *telegramClient) baseFormat(e zapcore.Entry, o entryOverrides, fields []zapcore.Field, parseMode string) string {
+	if f, ok := c.levelFormatters[e.Level]; ok && f != nil {
+		return f(e, fields)
+	}
+	if c.contextFormatter != nil {
+		return c.contextFormatter(c.entryContext(o), e, fields)
+	}
+	if c.formatter != nil {
+		return c.formatter(e, fields)
+	}
+	return c.defaultFormat(e, o, fields, parseMode)
+}
+
+// sendMessage sends a message to all specified chat ids (or the ones set through ChatField) concurrently.
+// A failing chat doesn't abort the delivery to the remaining ones, all the errors are joined and returned at the end.
+func (c *telegramClient) sendMessage(e zapcore.Entry, fields []zapcore.Field) (err error) {
+	defer recoverPanic(&err) // the message is rendered by the caller, see deliver
+	e, overrides, fields := c.prepareEntry(e, fields)
+	return c.deliver(e, overrides, func(parseMode string) message {
+		return c.renderMessage(e, overrides, fields, parseMode)
+	})
+}
+
+// prepareEntry returns the entry (with its message overridden, if any), its overrides and its regular fields
+func (c *telegramClient) prepareEntry(e zapcore.Entry, fields []zapcore.Field) (zapcore.Entry, entryOverrides, []zapcore.Field) {
+	overrides, fields := extractReservedFields(fields)
+	if c.threadResolver != nil {
+		if threadID, ok := c.threadResolver(e, fields); ok {
+			overrides.threadID = threadID
+		}
+	}
+	if overrides.threadID == 0 && c.packageThreads != nil {
+		overrides.threadID, _ = c.packageThreadID(e)
+	}
+	if c.topics != nil {
+		overrides.topicValue = c.topics.value(fields)
+	}
+	if c.notifyWhen != nil && c.notifyWhen(e, fields) {
+		overrides.notify = true
+	}
+	if c.messageOverride != nil {
+		if msg, ok := c.messageOverride(e, fields); ok {
+			e.Message = msg
+		}
+	}
+	return e, overrides, fields
+}
+
+// entryContext returns the context of the entry: the one set through ContextField, the core context
+// (see WithContext) or the background context
+func (c *telegramClient) entryContext(o entryOverrides) context.Context {
+	if o.ctx != nil {
+		return o.ctx
+	}
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+// entryChatIDs returns the chats of the entry: the ones set through ChatField (if any) or the default ones
+func (c *telegramClient) entryChatIDs(o entryOverrides) []int64 {
+	if len(o.chatIDs) > 0 {
+		return o.chatIDs
+	}
+	c.chatIDsMu.RLock()
+	defer c.chatIDsMu.RUnlock()
+	return c.chatIDs // never modified in place, see addChatID and removeChatID
+}
+
+// hasChats reports whether an entry with the given fields has any chat to be sent to (see ChatField)
+func (c *telegramClient) hasChats(fields []zapcore.Field) bool {
+	c.chatIDsMu.RLock()
+	defaults := len(c.chatIDs)
+	c.chatIDsMu.RUnlock()
+	if defaults > 0 {
+		return true
+	}
+	for _, f := range fields {
+		if f.Key == chatFieldKey {
+			return true
+		}
+	}
+	return false
+}
+
+// addChatID adds a chat id to the default ones
+func (c *telegramClient) addChatID(chatID int64) {
+	c.chatIDsMu.Lock()
+	defer c.chatIDsMu.Unlock()
+	if containsChatID(c.chatIDs, chatID) {
+		return
+	}
+	chatIDs := make([]int64, 0, len(c.chatIDs)+1)
+	c.chatIDs = append(append(chatIDs, c.chatIDs...), chatID)
+}
+
+// removeChatID removes a chat id from the default ones
+func (c *telegramClient) removeChatID(chatID int64) {
+	c.chatIDsMu.Lock()
+	defer c.chatIDsMu.Unlock()
+	chatIDs := make([]int64, 0, len(c.chatIDs))
+	for _, id := range c.chatIDs {
+		if id != chatID {
+			chatIDs = append(chatIDs, id)
+		}
+	}
+	c.chatIDs = chatIDs
+}
+
+// sendText sends a raw text (not a log entry) to all specified chat ids,
+// it's sent with the parse mode and notification settings of an info entry
+func (c *telegramClient) sendText(text string) error {
+	e := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: text}
+	return c.deliver(e, entryOverrides{}, func(string) message {
+		return message{body: text}
+	})
+}
+
+// deliver sends the message of an entry to all its chats concurrently (or edits the status message if any).
+// The message is rendered once for each of the parse modes used by the chats.
+// Different chats are sent in parallel but the messages of each chat are sent one at a time in the order
+// they were delivered, so the messages of a chat never arrive out of order.
+func (c *telegramClient) deliver(e zapcore.Entry, overrides entryOverrides, render func(parseMode string) message) error {
+	if c.statusMessage != nil {
+		return c.updateStatusMessage(e, overrides, render)
+	}
+	chatIDs := c.entryChatIDs(overrides)
+	msgs := make(map[string]message, 1)
+	for _, chatID := range chatIDs {
+		parseMode := c.chatParseMode(chatID)
+		if _, ok := msgs[parseMode]; !ok {
+			msgs[parseMode] = render(parseMode)
+		}
+	}
+	errs := make([]error, len(chatIDs))
+	var wg sync.WaitGroup
+	for i, chatID := range chatIDs {
+		i, chatID := i, chatID
+		wg.Add(1)
+		c.chatWorker(chatID).submit(func() error {
+			c.sendSlots <- struct{}{}
+			defer func() { <-c.sendSlots }()
+			parseMode := c.chatParseMode(chatID)
+			suppressed, ok := c.allowChatLevel(chatID, e.Level)
+			if !ok {
+				return nil
+			}
+			m := c.addSuppressedNote(msgs[parseMode], parseMode, suppressed)
+			return c.sendToChat(chatID, e, c.chatOverrides(chatID, overrides), parseMode, m, func() message {
+				return c.addSuppressedNote(render(""), "", suppressed)
+			})
+		}, func(err error) {
+			errs[i] = err
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// allowChatLevel reports whether a message of the given level can be sent to the chat (see WithPerChatLevelThrottle)
+// and, if so, how many messages were suppressed since the previous one
+func (c *telegramClient) allowChatLevel(chatID int64, l zapcore.Level) (int, bool) {
+	if c.chatLevelThrottle == nil {
+		return 0, true
+	}
+	allowed, suppressed := c.chatLevelThrottle.allow(chatID, l, time.Now())
+	if !allowed {
+		c.observer.dropped(l, DropReasonChatThrottled)
+	}
+	return suppressed, allowed
+}
+
+// addSuppressedNote adds a "(+N suppressed)" note before the footer of the message (if any messages were suppressed)
+func (c *telegramClient) addSuppressedNote(m message, parseMode string, suppressed int) message {
+	if suppressed == 0 {
+		return m
+	}
+	note := c.escape(parseMode, fmt.Sprintf("(+%d suppressed)", suppressed))
+	if m.footer != "" {
+		note += "\n" + m.footer
+	}
+	m.footer = note
+	return m
+}
+
+// sendToChat sends an already formatted message to a single chat id.
+// When Telegram can't parse the message it's sent once again as plain text (if any), so the alert isn't lost.
+func (c *telegramClient) sendToChat(chatID int64, e zapcore.Entry, o entryOverrides, parseMode string, m message, plain func() message) error {
+	extra := c.extraParams(o)
+	msgExtra := c.withMessageEffect(extra, e.Level)
+	if o.photo != nil {
+		return c.sendPhoto(chatID, e, o, parseMode, m, msgExtra)
+	}
+	sent, err := c.sendWithParams(e.Level, c.newChatMessage(chatID, e, o, parseMode, m), msgExtra)
+	if err != nil && parseMode != "" && m.entities == nil && plain != nil && isParseEntitiesError(err) {
+		c.handleError(fmt.Errorf("message to chat %d sent as plain text, failed to parse it as %s: %w", chatID, parseMode, err))
+		m = plain()
+		sent, err = c.sendWithParams(e.Level, c.newChatMessage(chatID, e, o, "", m), msgExtra)
+	}
+	if err != nil && isMessageTooLongError(err) {
+		// the message length is measured as Telegram does, but a self-hosted Bot API server may count otherwise
+		c.handleError(fmt.Errorf("message to chat %d sent as a document, it's too long: %w", chatID, err))
+		sent, err = c.sendWithParams(e.Level, c.newChatDocument(chatID, e, o, m.text()), msgExtra)
+	}
+	if err != nil {
+		err := fmt.Errorf("failed to send message to chat %d: %w", chatID, err)
+		c.observer.failed(e.Level, err)
+		return err
+	}
+	c.setLastMessageID(chatID, sent.MessageID)
+	c.observer.sent(e.Level)
+	if m.attachment != nil {
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: "fields.json", Bytes: m.attachment})
+		doc.DisableNotification = true // the message already notified (if enabled)
+		doc.ReplyToMessageID = sent.MessageID
+		if _, err := c.sendWithParams(e.Level, doc, extra); err != nil {
+			return fmt.Errorf("failed to send fields attachment to chat %d: %w", chatID, err)
+		}
+	}
+	return nil
+}
+
+// sendPhoto sends the photo attached to the entry with the formatted message as its caption
+// (or the photo caption if any), see Photo
+func (c *telegramClient) sendPhoto(chatID int64, e zapcore.Entry, o entryOverrides, parseMode string, m message, extra tgbotapi.Params) error {
+	data, err := o.photo.bytes()
+	if err != nil {
+		err := fmt.Errorf("failed to read photo for chat %d: %w", chatID, err)
+		c.observer.failed(e.Level, err)
+		return err
+	}
+	msg := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "photo", Bytes: data})
+	msg.DisableNotification = c.isNotificationDisabled(chatID, e, o)
+	msg.ReplyToMessageID = o.replyTo
+	msg.AllowSendingWithoutReply = o.replyTo != 0
+	switch caption := m.text(); {
+	case o.photo.caption != "":
+		msg.Caption = truncateText(o.photo.caption, maxCaptionLength)
+	case textLength(caption) <= maxCaptionLength:
+		msg.Caption = caption
+		msg.ParseMode = parseMode
+		if m.entities != nil {
+			msg.ParseMode = ""
+			msg.CaptionEntities = clipEntities(m.entities, caption)
 		}
+	default:
+		msg.Caption = truncateText(fmt.Sprintf("%s: %s", e.Level.CapitalString(), e.Message), maxCaptionLength)
+	}
+	sent, err := c.sendWithParams(e.Level, msg, extra)
+	if err != nil {
+		err := fmt.Errorf("failed to send photo to chat %d: %w", chatID, err)
+		c.observer.failed(e.Level, err)
+		return err
 	}
+	c.setLastMessageID(chatID, sent.MessageID)
+	c.observer.sent(e.Level)
 	return nil
 }
+
+// newChatMessage returns the message to send to the chat, messages longer than the large message threshold
+// (if any) are sent as a document
+func (c *telegramClient) newChatMessage(chatID int64, e zapcore.Entry, o entryOverrides, parseMode string, m message) tgbotapi.Chattable {
+	if text := m.text(); c.largeMessageThreshold > 0 && textLength(text) > c.largeMessageThreshold {
+		return c.newChatDocument(chatID, e, o, text)
+	}
+	textMsg := tgbotapi.NewMessage(chatID, c.truncateMessage(m, parseMode))
+	textMsg.DisableNotification = c.isNotificationDisabled(chatID, e, o)
+	textMsg.ReplyToMessageID = o.replyTo
+	textMsg.AllowSendingWithoutReply = o.replyTo != 0 // still sent if the replied message is gone
+	textMsg.ParseMode = parseMode
+	if m.entities != nil {
+		textMsg.ParseMode = ""
+		textMsg.Entities = clipEntities(m.entities, textMsg.Text)
+	}
+	return textMsg
+}
+
+// newChatDocument returns the message text to send to the chat as a document
+func (c *telegramClient) newChatDocument(chatID int64, e zapcore.Entry, o entryOverrides, text string) tgbotapi.DocumentConfig {
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: c.attachmentName(e), Bytes: []byte(text)})
+	doc.Caption = truncateText(fmt.Sprintf("%s: %s", e.Level.CapitalString(), e.Message), maxCaptionLength)
+	doc.DisableNotification = c.isNotificationDisabled(chatID, e, o)
+	doc.ReplyToMessageID = o.replyTo
+	doc.AllowSendingWithoutReply = o.replyTo != 0
+	return doc
+}
+
+// attachmentName returns the file name of the entry message sent as a document,
+// by default its level and time (E.g: error-2024-06-01-143000.txt)
+func (c *telegramClient) attachmentName(e zapcore.Entry) string {
+	if c.attachmentNamer != nil {
+		return c.attachmentNamer(e)
+	}
+	t := e.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return e.Level.String() + t.Format("-2006-01-02-150405") + ".txt"
+}
+
+// handleError reports an error to the error handler or writes it to the error output (stderr by default).
+// It never goes through a zap logger, which could contain this very core and log the error over and over again.
+func (c *telegramClient) handleError(err error) {
+	if err == nil {
+		return
+	}
+	if c.errorHandler != nil {
+		c.errorHandler(err)
+		return
+	}
+	if c.errorOutput != nil {
+		fmt.Fprintf(c.errorOutput, "%s zap2telegram: %v\n", time.Now().UTC().Format(time.RFC3339), err)
+	}
+}
+
+// setLastMessageID stores the id of the last message sent to the chat
+func (c *telegramClient) setLastMessageID(chatID int64, messageID int) {
+	c.lastMessageIDsMu.Lock()
+	defer c.lastMessageIDsMu.Unlock()
+	if c.lastMessageIDs == nil {
+		c.lastMessageIDs = map[int64]int{}
+	}
+	c.lastMessageIDs[chatID] = messageID
+}
+
+// getLastMessageIDs returns a copy of the ids of the last message sent to each chat
+func (c *telegramClient) getLastMessageIDs() map[int64]int {
+	c.lastMessageIDsMu.Lock()
+	defer c.lastMessageIDsMu.Unlock()
+	ids := make(map[int64]int, len(c.lastMessageIDs))
+	for chatID, messageID := range c.lastMessageIDs {
+		ids[chatID] = messageID
+	}
+	return ids
+}
+
+// chatParseMode returns the parse mode for the given chat, falling back to the global one (if any)
+func (c *telegramClient) chatParseMode(chatID int64) string {
+	if parseMode, ok := c.chatParseModes[chatID]; ok {
+		return parseMode
+	}
+	if c.parseMode != nil {
+		return *c.parseMode
+	}
+	return ""
+}
+
+// isNotificationDisabled reports whether the message for the given entry should be sent silently to the chat.
+// Precedence: SilentField, WithChatNotifications chats, WithNotifyWhen, WithNotificationOn levels,
+// WithNotifyAboveLevel threshold and the global setting.
+func (c *telegramClient) isNotificationDisabled(chatID int64, e zapcore.Entry, o entryOverrides) bool {
+	if o.silent {
+		return true
+	}
+	if enabled, ok := c.chatNotifications[chatID]; ok {
+		return !enabled
+	}
+	if o.notify {
+		return false
+	}
+	for _, level := range c.enableNotificationOnLevels {
+		if e.Level == level {
+			return false // enable notification for this message
+		}
+	}
+	if c.notifyAboveLevel != nil {
+		return e.Level < *c.notifyAboveLevel
+	}
+	return c.disableNotification
+}
+
+// truncateMessage returns the message text truncated to the max message length (the Telegram message length limit
+// by default) appending a truncated marker. The final text is always measured, no matter how it was formatted
+// (E.g: a custom formatter), so it never exceeds the Telegram message length limit.
+// Only the body is truncated so the code block fences and the footer are always kept. The body is never cut
+// in the middle of an HTML tag or entity (the open tags are closed) nor a MarkdownV2 escaped character,
+// see truncateFormatted.
+func (c *telegramClient) truncateMessage(m message, parseMode string) string {
+	text := m.text()
+	limit := maxMessageLength
+	if c.maxMessageLength > 0 && c.maxMessageLength < limit {
+		limit = c.maxMessageLength
+	}
+	if textLength(text) <= limit {
+		return text
+	}
+	if m.entities != nil {
+		parseMode = "" // ignored when using entities
+	}
+	limit -= textLength(m.blockOpen) + textLength(m.blockClose)
+	if m.footer != "" {
+		limit -= textLength(m.footer) + 1 // footer and its leading new line
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	marker := c.escape(parseMode, truncatedMarker)
+	keep := limit - textLength(marker)
+	if keep <= 0 {
+		m.body = truncateFormatted(m.body, limit, parseMode) // not even room for the marker
+	} else {
+		m.body = truncateFormatted(m.body, keep, parseMode) + marker
+	}
+	return truncateFormatted(m.text(), maxMessageLength, parseMode)
+}
+
+// truncateFormatted returns s cut to at most n UTF-16 code units without breaking its formatting under the parse mode:
+// HTML is never cut inside a tag or entity and the tags left open are closed, MarkdownV2 is never cut
+// between a backslash and the character it escapes
+func truncateFormatted(s string, n int, parseMode string) string {
+	if textLength(s) <= n {
+		return s
+	}
+	switch parseMode {
+	case tgbotapi.ModeHTML:
+		return truncateHTML(s, n)
+	case tgbotapi.ModeMarkdownV2:
+		cut := truncateText(s, n)
+		backslashes := len(cut) - len(strings.TrimRight(cut, "\\"))
+		if backslashes%2 == 1 { // the last backslash escapes the first character cut off
+			cut = cut[:len(cut)-1]
+		}
+		return cut
+	}
+	return truncateText(s, n)
+}
+
+// truncateHTML returns the HTML s cut to at most n UTF-16 code units (closing tags included) before any tag or entity
+// severed by the cut, closing the tags left open
+func truncateHTML(s string, n int) string {
+	for size := n; size > 0; {
+		cut := truncateText(s, size)
+		if i := strings.LastIndexByte(cut, '<'); i > strings.LastIndexByte(cut, '>') {
+			cut = cut[:i] // inside a tag
+		}
+		if i := strings.LastIndexByte(cut, '&'); i >= 0 && !strings.ContainsRune(cut[i:], ';') {
+			cut = cut[:i] // inside an entity, a literal & is always escaped as &amp;
+		}
+		closing := htmlClosingTags(cut)
+		overflow := textLength(cut) + textLength(closing) - n
+		if overflow <= 0 {
+			return cut + closing
+		}
+		size = textLength(cut) - overflow // make room for the closing tags
+		if size <= 0 {
+			size = textLength(cut) - 1 // cutting off a tag may leave room for its closing tag
+		}
+	}
+	return ""
+}
+
+// htmlClosingTags returns the closing tags of the tags left open in the HTML s (E.g: "</code></pre>")
+func htmlClosingTags(s string) string {
+	var open []string
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '>')
+		if end < 0 {
+			break
+		}
+		tag := s[start+1 : start+end]
+		s = s[start+end+1:]
+		if strings.HasPrefix(tag, "/") {
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+			continue
+		}
+		if name, _, _ := strings.Cut(tag, " "); name != "" {
+			open = append(open, name)
+		}
+	}
+	var closing strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		closing.WriteString("</" + open[i] + ">")
+	}
+	return closing.String()
+}
+
+// isValidParseMode reports whether the parse mode is one of the supported by Telegram
+func isValidParseMode(parseMode string) bool {
+	switch parseMode {
+	case tgbotapi.ModeMarkdown, tgbotapi.ModeMarkdownV2, tgbotapi.ModeHTML:
+		return true
+	}
+	return false
+}
+
+// clipEntities returns the entities clipped to the text length, so none of them points past a truncated text
+func clipEntities(entities []tgbotapi.MessageEntity, text string) []tgbotapi.MessageEntity {
+	length := textLength(text) // entities offsets are in UTF-16 code units too
+	clipped := make([]tgbotapi.MessageEntity, 0, len(entities))
+	for _, entity := range entities {
+		if entity.Offset >= length {
+			continue
+		}
+		if entity.Offset+entity.Length > length {
+			entity.Length = length - entity.Offset
+		}
+		clipped = append(clipped, entity)
+	}
+	return clipped
+}
+
+// textLength returns the length of s as counted by Telegram, in UTF-16 code units (E.g: 2 for "🚨")
+func textLength(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+// truncateText returns s cut to at most n UTF-16 code units, never in the middle of a character
+func truncateText(s string, n int) string {
+	length := 0
+	for i, r := range s {
+		if length += utf16RuneLen(r); length > n {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// utf16RuneLen returns the number of UTF-16 code units of r: 2 for the characters outside the basic multilingual
+// plane (E.g: most emoji), 1 otherwise
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,317 @@
+package zap2telegram
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+// fakeTelegram is a fake Telegram bot API server recording the requests it receives
+type fakeTelegram struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []fakeRequest
+	fail     func(r fakeRequest) *tgbotapi.Error // error to reply with (if any)
+}
+
+// fakeRequest is a request received by the fake Telegram bot API server
+type fakeRequest struct {
+	token  string
+	method string
+	params map[string]string
+}
+
+// badRequest returns a bad request error to reply with, see fakeTelegram.fail
+func badRequest(description string) *tgbotapi.Error {
+	return &tgbotapi.Error{Code: http.StatusBadRequest, Message: description}
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	f := &fakeTelegram{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseMultipartForm(1 << 20)
+		params := map[string]string{}
+		for k, v := range r.Form {
+			params[k] = v[0]
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/bot")
+		token, method, _ := strings.Cut(path, "/")
+		req := fakeRequest{token: token, method: method, params: params}
+		f.mu.Lock()
+		f.requests = append(f.requests, req)
+		id := len(f.requests)
+		fail := f.fail
+		f.mu.Unlock()
+		if fail != nil {
+			if err := fail(req); err != nil {
+				fmt.Fprintf(w, `{"ok":false,"error_code":%d,"description":%q,"parameters":{"retry_after":%d}}`,
+					err.Code, err.Message, err.RetryAfter)
+				return
+			}
+		}
+		if method == "getMe" {
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"chat":{"id":1},"date":0}}`, id)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// endpoint returns the bot API endpoint of the fake server, see WithAPIEndpoint
+func (f *fakeTelegram) endpoint() string {
+	return f.URL + "/bot%s/%s"
+}
+
+// sent returns the requests received for the given method
+func (f *fakeTelegram) sent(method string) []map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var params []map[string]string
+	for _, r := range f.requests {
+		if r.method == method {
+			params = append(params, r.params)
+		}
+	}
+	return params
+}
+
+func TestWithBotAPILeavesCallerClientUntouched(t *testing.T) {
+	f := newFakeTelegram(t)
+	client := &http.Client{}
+	bot, err := tgbotapi.NewBotAPIWithClient("token", f.endpoint(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, err := NewTelegramCore("", []int64{1}, WithBotAPI(bot), WithSendTimeout(time.Second), WithoutAsyncOpt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bot.Client != client {
+		t.Fatal("the caller's bot HTTP client was replaced")
+	}
+	if err := core.(*TelegramCore).SendTest("hello"); err != nil {
+		t.Fatalf("SendTest() = %v", err)
+	}
+	if n := len(f.sent("sendMessage")); n != 1 {
+		t.Fatalf("sent %d messages, want 1", n)
+	}
+}
+
+// dryRunCore returns a synchronous core recording the messages it would send
+func dryRunCore(t *testing.T, opts ...Option) (*TelegramCore, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var texts []string
+	opts = append([]Option{WithoutAsyncOpt(), WithDryRun(func(_ int64, text string) {
+		mu.Lock()
+		defer mu.Unlock()
+		texts = append(texts, text)
+	})}, opts...)
+	core, err := NewTelegramCore("", []int64{1}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return core.(*TelegramCore), &texts
+}
+
+func TestTruncateCustomFormatterMessage(t *testing.T) {
+	for _, char := range []string{"x", "🚨"} { // an emoji counts as 2 UTF-16 code units for Telegram
+		core, texts := dryRunCore(t, WithFormatter(func(zapcore.Entry, []zapcore.Field) string {
+			return strings.Repeat(char, 10000)
+		}))
+		if err := core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "long"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(*texts) != 1 {
+			t.Fatalf("sent %d messages, want 1", len(*texts))
+		}
+		text := (*texts)[0]
+		if n := textLength(text); n > maxMessageLength || n < maxMessageLength-1 {
+			t.Errorf("%q message length = %d, want %d", char, n, maxMessageLength)
+		}
+		if !strings.HasSuffix(text, truncatedMarker) {
+			t.Errorf("%q message doesn't end with the truncated marker", char)
+		}
+	}
+}
+
+func TestMessageTooLongSentAsDocument(t *testing.T) {
+	f := newFakeTelegram(t)
+	f.fail = func(r fakeRequest) *tgbotapi.Error {
+		if r.method == "sendMessage" {
+			return badRequest("Bad Request: message is too long")
+		}
+		return nil
+	}
+	var handled []error
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithErrorHandler(func(err error) { handled = append(handled, err) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "long"}, nil); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if got := len(f.sent("sendDocument")); got != 1 {
+		t.Errorf("sent %d documents, want 1", got)
+	}
+	if len(handled) != 1 || !isMessageTooLongError(handled[0]) {
+		t.Errorf("handled errors = %v, want the message too long one", handled)
+	}
+}
+
+func TestTruncateFormatted(t *testing.T) {
+	tests := []struct {
+		name      string
+		s         string
+		n         int
+		parseMode string
+		want      string
+	}{
+		{"short enough", "<b>bold</b>", 20, tgbotapi.ModeHTML, "<b>bold</b>"},
+		{"inside a tag", "ab<b>bold</b>", 4, tgbotapi.ModeHTML, "ab"},
+		{"open tag is closed", "<b>bold text</b>", 12, tgbotapi.ModeHTML, "<b>bold </b>"},
+		{"nested tags", "<b><i>bold italic</i></b>", 20, tgbotapi.ModeHTML, "<b><i>bold i</i></b>"},
+		{"attributes", `<a href="https://example.com">link</a> after`, 36, tgbotapi.ModeHTML, `<a href="https://example.com">li</a>`},
+		{"entity at the cut", "a &amp; b", 5, tgbotapi.ModeHTML, "a "},
+		{"entity before the cut", "a &amp; b", 8, tgbotapi.ModeHTML, "a &amp; "},
+		{"no room for the closing tags", "<pre><code>abc</code></pre>", 12, tgbotapi.ModeHTML, "<pre></pre>"},
+		{"markdownv2 escaped pair", `ab\.cd`, 3, tgbotapi.ModeMarkdownV2, "ab"},
+		{"markdownv2 escaped backslash", `ab\\cd`, 4, tgbotapi.ModeMarkdownV2, `ab\\`},
+		{"plain text", `ab\.cd`, 3, "", `ab\`},
+		{"emoji not split", "a🚨b", 2, "", "a"},
+		{"emoji fits", "a🚨b", 3, "", "a🚨"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateFormatted(tt.s, tt.n, tt.parseMode); got != tt.want {
+				t.Errorf("truncateFormatted(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateMessageAtTheLimit(t *testing.T) {
+	c := newTelegramClient("", nil)
+	marker := tgbotapi.EscapeText(tgbotapi.ModeHTML, truncatedMarker)
+	// the bold tag spans the message length limit
+	body := strings.Repeat("a", maxMessageLength-utf8.RuneCountInString(marker)-5) + "<b><i>bold</i></b> tail"
+	text := c.truncateMessage(message{body: body}, tgbotapi.ModeHTML)
+	if n := utf8.RuneCountInString(text); n > maxMessageLength {
+		t.Fatalf("message length = %d, want at most %d", n, maxMessageLength)
+	}
+	if want := "aaa" + marker; !strings.HasSuffix(text, want) {
+		t.Errorf("message ends with %q, want %q", text[len(text)-30:], want)
+	}
+
+	body = strings.Repeat(`\.`, maxMessageLength)
+	text = c.truncateMessage(message{body: body}, tgbotapi.ModeMarkdownV2)
+	if n := utf8.RuneCountInString(text); n > maxMessageLength {
+		t.Fatalf("message length = %d, want at most %d", n, maxMessageLength)
+	}
+	if trimmed := strings.TrimSuffix(text, tgbotapi.EscapeText(tgbotapi.ModeMarkdownV2, truncatedMarker)); strings.Count(trimmed, `\`) != strings.Count(trimmed, ".") {
+		t.Errorf("message cut between an escaped pair: %q", trimmed[len(trimmed)-10:])
+	}
+}
+
+func TestMessageEffectRejected(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		wantSent    int // messages sent without effect
+		wantErr     bool
+	}{
+		{"effect rejected", "Bad Request: EFFECT_ID_INVALID", 1, false},
+		{"other bad request", "Bad Request: chat not found", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeTelegram(t)
+			f.fail = func(r fakeRequest) *tgbotapi.Error {
+				if _, ok := r.params["message_effect_id"]; r.method == "sendMessage" && (ok || tt.wantErr) {
+					return badRequest(tt.description)
+				}
+				return nil
+			}
+			var handled []error
+			core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+				WithMessageEffect("5046509860389126442"), WithErrorHandler(func(err error) { handled = append(handled, err) }))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "deployed"}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() = %v, want error %v", err, tt.wantErr)
+			}
+			sent := 0
+			for _, params := range f.sent("sendMessage") {
+				if _, ok := params["message_effect_id"]; !ok {
+					sent++
+				}
+			}
+			if sent != tt.wantSent {
+				t.Errorf("sent %d messages without effect, want %d", sent, tt.wantSent)
+			}
+			if len(handled) != tt.wantSent {
+				t.Errorf("handled errors = %v, want %d", handled, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        int
+		wantErr     bool
+		wantPrimary int // messages sent with the primary bot
+		wantBackup  int // messages sent with the backup bot
+	}{
+		{"unauthorized", http.StatusUnauthorized, false, 1, 2},
+		{"rate limited", http.StatusTooManyRequests, false, 1, 2},
+		{"forbidden", http.StatusForbidden, true, 2, 0}, // blocked in the chat, the backup bot isn't used
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeTelegram(t)
+			f.fail = func(r fakeRequest) *tgbotapi.Error {
+				if r.token == "primary" && r.method == "sendMessage" {
+					return &tgbotapi.Error{Code: tt.code, Message: http.StatusText(tt.code)}
+				}
+				return nil
+			}
+			core, err := NewTelegramCore("primary", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+				WithBackupBots("backup"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				if err := core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "down"}, nil); (err != nil) != tt.wantErr {
+					t.Fatalf("Write() = %v, want error %v", err, tt.wantErr)
+				}
+			}
+			sent := map[string]int{}
+			f.mu.Lock()
+			for _, r := range f.requests {
+				if r.method == "sendMessage" {
+					sent[r.token]++
+				}
+			}
+			f.mu.Unlock()
+			if sent["primary"] != tt.wantPrimary || sent["backup"] != tt.wantBackup {
+				t.Errorf("sent %d messages with the primary bot and %d with the backup one, want %d and %d",
+					sent["primary"], sent["backup"], tt.wantPrimary, tt.wantBackup)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package zap2telegram
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// templateData is the data exposed to the message templates set with WithTemplate
+type templateData struct {
+	Level      zapcore.Level
+	Time       time.Time
+	Message    string
+	LoggerName string
+	Caller     string
+	Stack      string
+	Fields     map[string]interface{}
+}
+
+// newTemplateFormatter compiles the given template and returns a Telegram message formatter using it
+func newTemplateFormatter(tmpl string) (func(e zapcore.Entry, fields []zapcore.Field) string, error) {
+	t, err := template.New("zap2telegram").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message template: %w", err)
+	}
+	return func(e zapcore.Entry, fields []zapcore.Field) string {
+		enc := zapcore.NewMapObjectEncoder()
+		for _, field := range fields {
+			field.AddTo(enc)
+		}
+		caller := ""
+		if e.Caller.Defined {
+			caller = e.Caller.TrimmedPath()
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, templateData{
+			Level:      e.Level,
+			Time:       e.Time,
+			Message:    e.Message,
+			LoggerName: e.LoggerName,
+			Caller:     caller,
+			Stack:      e.Stack,
+			Fields:     enc.Fields,
+		}); err != nil {
+			// the message is still sent, so the error doesn't go unnoticed
+			return fmt.Sprintf("%s\n(failed to execute message template: %v)", e.Message, err)
+		}
+		return b.String()
+	}, nil
+}
@@ -0,0 +1,111 @@
+package zap2telegram
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// throttle caps the messages sent across all levels during a sliding time window
+type throttle struct {
+	mu       sync.Mutex
+	max      int           // max messages per window
+	window   time.Duration // sliding window length
+	sent     []time.Time   // times of the messages sent during the current window (oldest first)
+	noticeAt time.Time     // last time the throttle notice was sent
+}
+
+func newThrottle(max int, window time.Duration) *throttle {
+	return &throttle{max: max, window: window, sent: make([]time.Time, 0, max)}
+}
+
+// allow reports whether a message can be sent at the given time, otherwise whether the throttle notice
+// should be sent (once per window)
+func (t *throttle) allow(now time.Time) (allowed, notice bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expired := 0
+	for expired < len(t.sent) && now.Sub(t.sent[expired]) >= t.window {
+		expired++
+	}
+	t.sent = append(t.sent[:0], t.sent[expired:]...)
+	if len(t.sent) < t.max {
+		t.sent = append(t.sent, now)
+		return true, false
+	}
+	if t.noticeAt.IsZero() || now.Sub(t.noticeAt) >= t.window {
+		t.noticeAt = now
+		return false, true
+	}
+	return false, false
+}
+
+// levelRateLimiter enforces a minimum interval between the messages sent for each level
+type levelRateLimiter struct {
+	mu        sync.Mutex
+	intervals map[zapcore.Level]time.Duration // min interval between messages per level, levels not listed aren't limited
+	lastSent  map[zapcore.Level]time.Time     // last time a message was let through per level
+}
+
+func newLevelRateLimiter(intervals map[zapcore.Level]time.Duration) *levelRateLimiter {
+	return &levelRateLimiter{intervals: intervals, lastSent: map[zapcore.Level]time.Time{}}
+}
+
+// allow reports whether a message of the given level can be sent at the given time
+func (r *levelRateLimiter) allow(l zapcore.Level, now time.Time) bool {
+	interval, ok := r.intervals[l]
+	if !ok || interval <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.lastSent[l]; ok && now.Sub(last) < interval {
+		return false
+	}
+	r.lastSent[l] = now
+	return true
+}
+
+// chatLevelKey identifies the messages of a level sent to a chat
+type chatLevelKey struct {
+	chatID int64
+	level  zapcore.Level
+}
+
+// chatLevelThrottle enforces a minimum interval between the messages of each level sent to each chat,
+// counting the ones suppressed in between so they can be reported by the next allowed message
+type chatLevelThrottle struct {
+	mu         sync.Mutex
+	intervals  map[zapcore.Level]time.Duration // min interval between messages per level, levels not listed aren't limited
+	lastSent   map[chatLevelKey]time.Time      // last time a message was let through per chat and level
+	suppressed map[chatLevelKey]int            // messages suppressed since the last one let through per chat and level
+}
+
+func newChatLevelThrottle() *chatLevelThrottle {
+	return &chatLevelThrottle{
+		intervals:  map[zapcore.Level]time.Duration{},
+		lastSent:   map[chatLevelKey]time.Time{},
+		suppressed: map[chatLevelKey]int{},
+	}
+}
+
+// allow reports whether a message of the given level can be sent to the chat at the given time and,
+// if so, how many messages were suppressed since the previous one
+func (t *chatLevelThrottle) allow(chatID int64, l zapcore.Level, now time.Time) (allowed bool, suppressed int) {
+	interval, ok := t.intervals[l]
+	if !ok || interval <= 0 {
+		return true, 0
+	}
+	key := chatLevelKey{chatID: chatID, level: l}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.lastSent[key]; ok && now.Sub(last) < interval {
+		t.suppressed[key]++
+		return false, 0
+	}
+	t.lastSent[key] = now
+	suppressed = t.suppressed[key]
+	delete(t.suppressed, key)
+	return true, suppressed
+}
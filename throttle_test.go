@@ -0,0 +1,75 @@
+package zap2telegram
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestGlobalThrottle(t *testing.T) {
+	const window = 50 * time.Millisecond
+	f := newFakeTelegram(t)
+	core, err := NewTelegramCore("token", []int64{1}, WithAPIEndpoint(f.endpoint()), WithoutAsyncOpt(),
+		WithGlobalThrottle(3, window))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := core.(*TelegramCore)
+	write := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if err := tc.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "storm"}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tc.Sync(); err != nil { // the throttle notice is sent in the background
+			t.Fatal(err)
+		}
+	}
+	write(6)
+	sent := f.sent("sendMessage")
+	if len(sent) != 4 {
+		t.Fatalf("sent %d messages, want 3 and the throttle notice", len(sent))
+	}
+	notices := 0
+	for _, params := range sent {
+		if strings.HasPrefix(params["text"], "(throttled)") {
+			notices++
+		}
+	}
+	if notices != 1 {
+		t.Errorf("sent %d throttle notices, want 1", notices)
+	}
+	if got := tc.Dropped(); got != 3 {
+		t.Errorf("Dropped() = %d, want 3", got)
+	}
+
+	time.Sleep(window) // the window slides past the sent messages
+	write(1)
+	if got := len(f.sent("sendMessage")); got != 5 {
+		t.Errorf("sent %d messages, want 5", got)
+	}
+}
+
+func TestPerChatLevelThrottleInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		_, err := NewTelegramCore("", []int64{1}, WithDryRun(func(int64, string) {}),
+			WithPerChatLevelThrottle(zapcore.ErrorLevel, interval))
+		if !errors.Is(err, ErrPerChatLevelThrottle) {
+			t.Errorf("NewTelegramCore(interval %s) = %v, want %v", interval, err, ErrPerChatLevelThrottle)
+		}
+	}
+}
+
+func TestLevelRateLimitInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		_, err := NewTelegramCore("", []int64{1}, WithDryRun(func(int64, string) {}),
+			WithLevelRateLimit(map[zapcore.Level]time.Duration{zapcore.InfoLevel: time.Minute, zapcore.ErrorLevel: interval}))
+		if !errors.Is(err, ErrLevelRateLimit) {
+			t.Errorf("NewTelegramCore(interval %s) = %v, want %v", interval, err, ErrLevelRateLimit)
+		}
+	}
+}
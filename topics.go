@@ -0,0 +1,172 @@
+package zap2telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	topicIdleTimeout   = time.Hour // the topics unused for this long are forgotten, see WithThreadByField
+	maxTopicNameLength = 128       // max forum topic name length (in UTF-16 code units)
+)
+
+// topicKey identifies the forum topic of a field value in a chat
+type topicKey struct {
+	chatID int64
+	value  string
+}
+
+// topic is a forum topic created for a field value
+type topic struct {
+	threadID int
+	err      error         // creation error, the topic is forgotten so it's created again next time
+	created  chan struct{} // closed once the topic is created (or failed to be)
+	lastUsed time.Time
+}
+
+// topicCache maps the values of a field to the forum topics created for them in each chat, see WithThreadByField
+type topicCache struct {
+	mu             sync.Mutex
+	key            string // field whose values have their own topic
+	idle           time.Duration
+	topics         map[topicKey]*topic
+	nextExpiration time.Time // next time the idle topics are forgotten
+}
+
+func newTopicCache(key string) *topicCache {
+	return &topicCache{key: key, idle: topicIdleTimeout, topics: map[topicKey]*topic{}}
+}
+
+// value returns the value of the field of the entry (if any)
+func (t *topicCache) value(fields []zapcore.Field) string {
+	for _, f := range fields {
+		if f.Key == t.key {
+			return fieldValueString(f)
+		}
+	}
+	return ""
+}
+
+// threadID returns the thread id of the topic of the value in the chat, creating it with create if needed.
+// The topic is created without holding the lock, the concurrent lookups of the same topic wait for its creation.
+func (t *topicCache) threadID(chatID int64, value string, create func() (int, error)) (int, error) {
+	now := time.Now()
+	key := topicKey{chatID: chatID, value: value}
+	t.mu.Lock()
+	t.expire(now)
+	tp, ok := t.topics[key]
+	if ok && now.Sub(tp.lastUsed) < t.idle {
+		tp.lastUsed = now
+		t.mu.Unlock()
+		<-tp.created
+		return tp.threadID, tp.err
+	}
+	tp = &topic{created: make(chan struct{}), lastUsed: now}
+	t.topics[key] = tp
+	t.mu.Unlock()
+
+	tp.threadID, tp.err = create()
+	close(tp.created)
+	if tp.err != nil {
+		t.mu.Lock()
+		if t.topics[key] == tp {
+			delete(t.topics, key)
+		}
+		t.mu.Unlock()
+	}
+	return tp.threadID, tp.err
+}
+
+// expire forgets the idle topics so the cache doesn't grow forever, at most once per idle timeout
+func (t *topicCache) expire(now time.Time) {
+	if now.Before(t.nextExpiration) {
+		return
+	}
+	t.nextExpiration = now.Add(t.idle)
+	for k, tp := range t.topics {
+		if now.Sub(tp.lastUsed) >= t.idle {
+			delete(t.topics, k)
+		}
+	}
+}
+
+// chatOverrides returns the overrides of the entry for the chat: the entry is sent to the forum topic of its
+// field value in the chat (see WithThreadByField), it's sent as usual if the topic can't be created
+func (c *telegramClient) chatOverrides(chatID int64, o entryOverrides) entryOverrides {
+	if c.topics == nil || o.topicValue == "" || c.isOffline() {
+		return o
+	}
+	threadID, err := c.topics.threadID(chatID, o.topicValue, func() (int, error) {
+		return c.createForumTopic(chatID, c.topics.key+": "+o.topicValue)
+	})
+	if err != nil {
+		c.handleError(fmt.Errorf("failed to create forum topic in chat %d: %w", chatID, err))
+		return o
+	}
+	o.threadID = threadID
+	return o
+}
+
+// createForumTopic creates a forum topic in the chat returning its thread id,
+// the bot API library doesn't support forum topics
+func (c *telegramClient) createForumTopic(chatID int64, name string) (int, error) {
+	params := tgbotapi.Params{"chat_id": strconv.FormatInt(chatID, 10), "name": truncateText(name, maxTopicNameLength)}
+	resp, err := c.bot(int(c.activeBot.Load())).MakeRequest("createForumTopic", params)
+	if err != nil {
+		return 0, err
+	}
+	var created struct {
+		MessageThreadID int `json:"message_thread_id"`
+	}
+	if err := json.Unmarshal(resp.Result, &created); err != nil {
+		return 0, err
+	}
+	return created.MessageThreadID, nil
+}
+
+// callerPackage returns the import path of the package of the function (E.g: "github.com/acme/shop/payments" for
+// "github.com/acme/shop/payments.(*Client).Charge")
+func callerPackage(function string) string {
+	dir, name := "", function
+	if i := strings.LastIndexByte(function, '/'); i >= 0 {
+		dir, name = function[:i+1], function[i+1:]
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return dir + name
+}
+
+// matchesPackage reports whether the package pattern matches the package path: the pattern matches the package
+// with the same path or path suffix (E.g: "payments" matches "github.com/acme/shop/payments") and,
+// if it ends with "/*", its subpackages too (E.g: "payments/*" matches "github.com/acme/shop/payments/stripe")
+func matchesPackage(pattern, pkg string) bool {
+	prefix, subpackages := strings.CutSuffix(pattern, "/*")
+	if pkg == prefix || strings.HasSuffix(pkg, "/"+prefix) {
+		return true
+	}
+	return subpackages && (strings.HasPrefix(pkg, prefix+"/") || strings.Contains(pkg, "/"+prefix+"/"))
+}
+
+// packageThreadID returns the forum topic of the package of the entry caller, the one of the longest
+// matching pattern, see WithPackageThreadMap
+func (c *telegramClient) packageThreadID(e zapcore.Entry) (int, bool) {
+	if !e.Caller.Defined || e.Caller.Function == "" {
+		return 0, false
+	}
+	pkg := callerPackage(e.Caller.Function)
+	threadID, matched := 0, ""
+	for pattern, id := range c.packageThreads {
+		if len(pattern) > len(matched) && matchesPackage(pattern, pkg) {
+			threadID, matched = id, pattern
+		}
+	}
+	return threadID, matched != ""
+}
@@ -0,0 +1,75 @@
+package zap2telegram
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTopicCreationDoesNotBlockOtherTopics(t *testing.T) {
+	cache := newTopicCache("tenant")
+	release := make(chan struct{})
+	var creations atomic.Int32
+	slow := func() (int, error) {
+		creations.Add(1)
+		<-release
+		return 10, nil
+	}
+	var wg sync.WaitGroup
+	threadIDs := make([]int, 3)
+	for i := range threadIDs {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			threadIDs[i], _ = cache.threadID(1, "acme", slow)
+		}()
+	}
+	done := make(chan int)
+	go func() {
+		threadID, _ := cache.threadID(2, "acme", func() (int, error) { return 20, nil })
+		done <- threadID
+	}()
+	select {
+	case threadID := <-done:
+		if threadID != 20 {
+			t.Errorf("threadID() = %d, want 20", threadID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the topic of chat 2 is blocked by the creation of the one of chat 1")
+	}
+	close(release)
+	wg.Wait()
+	if got := creations.Load(); got != 1 {
+		t.Errorf("the topic of chat 1 was created %d times, want once", got)
+	}
+	for _, threadID := range threadIDs {
+		if threadID != 10 {
+			t.Errorf("threadID() = %d, want 10", threadID)
+		}
+	}
+}
+
+func TestTopicCacheFailedAndIdleTopics(t *testing.T) {
+	cache := newTopicCache("tenant")
+	cache.idle = 20 * time.Millisecond
+	if _, err := cache.threadID(1, "acme", func() (int, error) { return 0, errors.New("not a forum") }); err == nil {
+		t.Fatal("threadID() succeeded, want the creation error")
+	}
+	created := 0
+	create := func() (int, error) {
+		created++
+		return created, nil
+	}
+	for i := 0; i < 2; i++ {
+		if threadID, err := cache.threadID(1, "acme", create); err != nil || threadID != 1 {
+			t.Fatalf("threadID() = %d, %v, want 1 (the failed topic is created again)", threadID, err)
+		}
+	}
+	time.Sleep(cache.idle)
+	if threadID, err := cache.threadID(1, "acme", create); err != nil || threadID != 2 {
+		t.Errorf("threadID() = %d, %v, want 2 (the idle topic is created again)", threadID, err)
+	}
+}
@@ -0,0 +1,78 @@
+package zap2telegram
+
+import (
+	"fmt"
+	"sync"
+)
+
+// chatWorker sends the messages of a single chat one at a time, in the same order they were submitted.
+// Its goroutine only runs while there are messages to send, so idle chats cost nothing.
+type chatWorker struct {
+	mu      sync.Mutex
+	jobs    []workerJob
+	running bool
+}
+
+// workerJob is a job submitted to a chat worker
+type workerJob struct {
+	run  func() error
+	done func(err error) // receives the job error, the job panic is turned into an error
+}
+
+// submit adds a job to the worker, starting the worker goroutine if it's not running.
+// done is called with the job error once it has run.
+func (w *chatWorker) submit(job func() error, done func(err error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.jobs = append(w.jobs, workerJob{run: job, done: done})
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+// run runs the submitted jobs in order until there are no more left
+func (w *chatWorker) run() {
+	for {
+		w.mu.Lock()
+		if len(w.jobs) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		job := w.jobs[0]
+		w.jobs[0] = workerJob{}
+		w.jobs = w.jobs[1:]
+		w.mu.Unlock()
+		job.done(runJob(job.run))
+	}
+}
+
+// runJob runs a job returning its error, a panic (E.g: in a custom formatter or tap) mustn't crash the process
+// nor stop the worker
+func runJob(job func() error) (err error) {
+	defer recoverPanic(&err)
+	return job()
+}
+
+// recoverPanic turns a panic (E.g: in a custom formatter) into the error of the message being sent, it must be deferred
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("panic sending message: %v", r)
+	}
+}
+
+// chatWorker returns the worker of the given chat
+func (c *telegramClient) chatWorker(chatID int64) *chatWorker {
+	c.chatWorkersMu.Lock()
+	defer c.chatWorkersMu.Unlock()
+	w, ok := c.chatWorkers[chatID]
+	if !ok {
+		if c.chatWorkers == nil {
+			c.chatWorkers = map[int64]*chatWorker{}
+		}
+		w = &chatWorker{}
+		c.chatWorkers[chatID] = w
+	}
+	return w
+}